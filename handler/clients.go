@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"docker.io/go-docker"
+	"github.com/op/go-logging"
+	"github.com/openbaton/go-openbaton/catalogue"
+)
+
+const (
+	// maxFailures is the number of consecutive failed connections, handshakes, reads or writes after which a client is
+	// rebuilt
+	maxFailures = 3
+	// tlsHandshakeTimeout bounds the tls handshake of the connections to the docker hosts
+	tlsHandshakeTimeout = 10 * time.Second
+)
+
+// clientBuilder creates a docker client whose connections are passed to dialed as they are dialed, together with what
+// has to be closed with it, if anything.
+type clientBuilder func(dialed func(net.Conn, error) (net.Conn, error)) (*docker.Client, io.Closer, error)
+
+// managedClient is a docker client together with what is needed to tell whether it is still usable.
+type managedClient struct {
+	cl     *docker.Client
+	closer io.Closer
+	// host and fingerprint identify the vim instance configuration the client was built from
+	host        string
+	fingerprint string
+	// failures counts the consecutive failures of the connections
+	failures int32
+
+	mu sync.Mutex
+	// conns counts the open connections
+	conns int
+	// retired clients are not handed out anymore and are closed once they have no open connections left
+	retired bool
+	closed  bool
+}
+
+// dialed records the outcome of a connection and returns the connection wrapped so that its use is recorded too.
+func (c *managedClient) dialed(conn net.Conn, err error) (net.Conn, error) {
+	if err != nil {
+		atomic.AddInt32(&c.failures, 1)
+		return nil, err
+	}
+	atomic.StoreInt32(&c.failures, 0)
+	c.mu.Lock()
+	c.conns++
+	c.mu.Unlock()
+	return &managedConn{Conn: conn, client: c}, nil
+}
+
+// used records the outcome of a read or write on a connection. Errors of connections being closed by the client are
+// not failures of the host, and only reads reset the failures since writes succeed before the host answers.
+func (c *managedClient) used(read bool, n int, err error, closing bool) {
+	switch {
+	case err != nil && err != io.EOF && !closing:
+		atomic.AddInt32(&c.failures, 1)
+	case read && n > 0:
+		atomic.StoreInt32(&c.failures, 0)
+	}
+}
+
+func (c *managedClient) connClosed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conns--
+	c.closeIfUnused()
+}
+
+// closeIfUnused closes what the client holds once it is retired and has no open connections, only the first time. The
+// caller must hold the lock.
+func (c *managedClient) closeIfUnused() {
+	if !c.retired || c.conns > 0 || c.closed {
+		return
+	}
+	c.closed = true
+	if c.closer != nil {
+		c.closer.Close()
+	}
+}
+
+func (c *managedClient) healthy() bool {
+	return atomic.LoadInt32(&c.failures) < maxFailures
+}
+
+// retire closes the idle connections of the client, the rest of it is closed once the requests still using it are
+// done. Closing it right away would break them, since it may have been handed out to operations still running.
+func (c *managedClient) retire() {
+	c.mu.Lock()
+	c.retired = true
+	c.mu.Unlock()
+	// closing the idle connections calls connClosed for each of them, so the lock must not be held
+	c.cl.Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeIfUnused()
+}
+
+// managedConn is a connection of a managedClient.
+type managedConn struct {
+	net.Conn
+	client  *managedClient
+	closing int32
+	once    sync.Once
+}
+
+func (c *managedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.client.used(true, n, err, atomic.LoadInt32(&c.closing) == 1)
+	return n, err
+}
+
+func (c *managedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.client.used(false, n, err, atomic.LoadInt32(&c.closing) == 1)
+	return n, err
+}
+
+func (c *managedConn) Close() error {
+	atomic.StoreInt32(&c.closing, 1)
+	err := c.Conn.Close()
+	c.once.Do(c.client.connClosed)
+	return err
+}
+
+// clientManager keeps one docker client per docker host, validating new clients and rebuilding them when the vim
+// instance changes or the host cannot be reached anymore. Replaced clients are retired, see managedClient.retire.
+type clientManager struct {
+	mu      sync.Mutex
+	clients map[string]*managedClient
+}
+
+func newClientManager() *clientManager {
+	return &clientManager{
+		clients: make(map[string]*managedClient),
+	}
+}
+
+// get returns the client for the docker host of instance, building it with build if there is none usable. New clients
+// are only returned once the host answered a ping within ctx and, unless the vim instance sets the api version, use the
+// highest api version supported by both sides.
+func (m *clientManager) get(ctx context.Context, instance *catalogue.DockerVimInstance, logger *logging.Logger, build clientBuilder) (*docker.Client, error) {
+	host := instance.AuthURL
+	fingerprint := clientFingerprint(instance)
+	key := host + "\x00" + fingerprint
+	m.mu.Lock()
+	m.retireChanged(host, fingerprint, logger)
+	if c, ok := m.clients[key]; ok {
+		if c.healthy() {
+			m.mu.Unlock()
+			return c.cl, nil
+		}
+		logger.Noticef("Rebuilding the client for %s after %d failures", host, maxFailures)
+		delete(m.clients, key)
+		c.retire()
+	}
+	m.mu.Unlock()
+
+	// building and pinging happen without the lock, so that an unreachable host does not block the others
+	c := &managedClient{host: host, fingerprint: fingerprint}
+	cl, closer, err := build(c.dialed)
+	if err != nil {
+		return nil, fmt.Errorf("error creating client for %s: %v", host, err)
+	}
+	c.cl = cl
+	c.closer = closer
+	ping, err := cl.Ping(ctx)
+	if err != nil {
+		c.retire()
+		if isCertificateError(err) {
+			return nil, &CertificateError{Host: host, Reason: fmt.Sprintf("the docker host is not trusted: %v", err)}
+		}
+		return nil, fmt.Errorf("docker host %s is not reachable: %v", host, err)
+	}
+	if version, _ := getAPIVersion(instance); version == "" {
+		cl.NegotiateAPIVersionPing(ping)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retireChanged(host, fingerprint, logger)
+	if other, ok := m.clients[key]; ok {
+		if other.healthy() {
+			c.retire()
+			return other.cl, nil
+		}
+		other.retire()
+	}
+	m.clients[key] = c
+	logger.Debugf("Created client for %s with api version %s", host, cl.ClientVersion())
+	return cl, nil
+}
+
+// retireChanged retires the clients for host built from another configuration of the vim instance. The caller must
+// hold the lock.
+func (m *clientManager) retireChanged(host, fingerprint string, logger *logging.Logger) {
+	for key, c := range m.clients {
+		if c.host == host && c.fingerprint != fingerprint {
+			logger.Noticef("Configuration for %s changed, retiring its old client", host)
+			delete(m.clients, key)
+			c.retire()
+		}
+	}
+}
+
+// clientFingerprint hashes the fields of instance used to build its client.
+func clientFingerprint(instance *catalogue.DockerVimInstance) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		instance.AuthURL, instance.Ca, instance.Cert, instance.DockerKey, instance.Tenant, instance.Metadata[apiVersionKey],
+	}, "\x00")))
+	return fmt.Sprintf("%x", sum)
+}
+
+// trackingDial wraps dial so that every connection is passed to dialed.
+func trackingDial(dial func(network, addr string) (net.Conn, error), dialed func(net.Conn, error) (net.Conn, error)) func(network, addr string) (net.Conn, error) {
+	return func(network, addr string) (net.Conn, error) {
+		return dialed(dial(network, addr))
+	}
+}
+
+// tlsDial wraps dial so that the connections are tls connections using config, handshaken before they are returned.
+// Handshaking here rather than in the transport lets the handshake failures be passed to dialed by trackingDial.
+func tlsDial(dial func(network, addr string) (net.Conn, error), config *tls.Config) func(network, addr string) (net.Conn, error) {
+	return func(network, addr string) (net.Conn, error) {
+		conn, err := dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg := config.Clone()
+		if cfg.ServerName == "" {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				host = addr
+			}
+			cfg.ServerName = host
+		}
+		tlsConn := tls.Client(conn, cfg)
+		conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn.SetDeadline(time.Time{})
+		return tlsConn, nil
+	}
+}
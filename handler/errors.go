@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NotFoundError is returned when the requested resource does not exist on the docker engine, as opposed to errors
+// returned by a failing docker API call.
+type NotFoundError struct {
+	Kind string
+	ID   string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s %s not found", e.Kind, e.ID)
+}
+
+func IsNotFound(err error) bool {
+	_, ok := err.(*NotFoundError)
+	return ok
+}
+
+// NetworkInUseError is returned when a network cannot be deleted because containers are still attached to it.
+type NetworkInUseError struct {
+	Network    string
+	Containers []string
+}
+
+func (e *NetworkInUseError) Error() string {
+	return fmt.Sprintf("network %s has attached containers: %s", e.Network, strings.Join(e.Containers, ", "))
+}
+
+// NotManagedError is returned when the driver is asked to change a resource it did not create.
+type NotManagedError struct {
+	Kind string
+	Name string
+}
+
+func (e *NotManagedError) Error() string {
+	return fmt.Sprintf("%s %s was not created by the driver", e.Kind, e.Name)
+}
+
+// CertificateError is returned when the certificates used to connect to a docker host, or the one presented by the
+// host, are not valid.
+type CertificateError struct {
+	Host   string
+	Reason string
+}
+
+func (e *CertificateError) Error() string {
+	return fmt.Sprintf("certificate error for docker host %s: %s", e.Host, e.Reason)
+}
+
+// UnsupportedError is returned when the api version used with a docker engine does not support a requested feature.
+type UnsupportedError struct {
+	Feature  string
+	Version  string
+	Required string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s unsupported by engine version %s, at least %s is required", e.Feature, e.Version, e.Required)
+}
+
+// TimeoutError is returned when an operation did not complete within its timeout, its docker calls being cancelled.
+type TimeoutError struct {
+	Operation Operation
+	Timeout   time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s operation timed out after %v", e.Operation, e.Timeout)
+}
+
+func IsTimeout(err error) bool {
+	_, ok := err.(*TimeoutError)
+	return ok
+}
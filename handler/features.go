@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"docker.io/go-docker"
+	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/versions"
+	"github.com/openbaton/go-openbaton/catalogue"
+)
+
+// apiVersionKey is the vim instance metadata key with the docker api version to use instead of negotiating it, when the
+// tenant of the vim instance is empty
+const apiVersionKey = "api-version"
+
+// minimum api versions of the features not supported by every docker engine
+const (
+	swarmAPIVersion      = "1.24"
+	attachableAPIVersion = "1.25"
+	nanoCPUsAPIVersion   = "1.25"
+)
+
+var apiVersionRegexp = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+
+// getAPIVersion returns the api version set as tenant of instance or in its metadata, or an empty string if it has to
+// be negotiated.
+func getAPIVersion(instance *catalogue.DockerVimInstance) (string, error) {
+	value := instance.Tenant
+	if strings.TrimSpace(value) == "" {
+		value = instance.Metadata[apiVersionKey]
+	}
+	version := strings.TrimPrefix(strings.TrimSpace(value), "v")
+	if version != "" && !apiVersionRegexp.MatchString(version) {
+		return "", fmt.Errorf("invalid api version %s of vim instance %s", value, instance.Name)
+	}
+	return version, nil
+}
+
+// requireVersion returns an UnsupportedError if the api version used by cl is older than version.
+func requireVersion(cl *docker.Client, feature, version string) error {
+	if versions.LessThan(cl.ClientVersion(), version) {
+		return &UnsupportedError{Feature: feature, Version: cl.ClientVersion(), Required: version}
+	}
+	return nil
+}
+
+// checkNetworkFeatures checks that the docker engine supports the features needed to create the network.
+func (h PluginImpl) checkNetworkFeatures(cl *docker.Client, create types.NetworkCreate) error {
+	if h.Swarm {
+		if err := requireVersion(cl, "swarm mode", swarmAPIVersion); err != nil {
+			return err
+		}
+	}
+	if create.Attachable {
+		return requireVersion(cl, "attachable networks", attachableAPIVersion)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"docker.io/go-docker/api/types/container"
+	"github.com/openbaton/go-openbaton/catalogue"
+)
+
+// flavourLabel is the container label holding the key of the flavour it was launched with
+const flavourLabel = "org.openbaton.flavour"
+
+// defaultFlavour does not limit the resources of the container and is always available, it can be updated but not
+// deleted
+var defaultFlavour = catalogue.DeploymentFlavour{
+	ExtID:      "12345",
+	FlavourKey: "m1.small",
+}
+
+// flavourRegistry keeps the flavours by external id and saves them into a json file, if a path is given, every time they
+// change.
+type flavourRegistry struct {
+	mu       sync.RWMutex
+	path     string
+	flavours map[string]*catalogue.DeploymentFlavour
+}
+
+func newFlavourRegistry() *flavourRegistry {
+	df := defaultFlavour
+	return &flavourRegistry{
+		flavours: map[string]*catalogue.DeploymentFlavour{df.ExtID: &df},
+	}
+}
+
+// load replaces the flavours with those saved in the file at path, if it exists, and saves there from now on. The
+// default flavour is added if the file does not have it.
+func (r *flavourRegistry) load(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.path = path
+	if !exists(path) {
+		return nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var flavours []*catalogue.DeploymentFlavour
+	if err = json.Unmarshal(content, &flavours); err != nil {
+		return errors.New(fmt.Sprintf("Not able to read flavours from %s: %v", path, err))
+	}
+	r.flavours = make(map[string]*catalogue.DeploymentFlavour)
+	for _, f := range flavours {
+		r.flavours[f.ExtID] = f
+	}
+	if _, ok := r.flavours[defaultFlavour.ExtID]; !ok {
+		df := defaultFlavour
+		r.flavours[df.ExtID] = &df
+	}
+	return nil
+}
+
+func (r *flavourRegistry) list() []*catalogue.DeploymentFlavour {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.sorted()
+}
+
+func (r *flavourRegistry) sorted() []*catalogue.DeploymentFlavour {
+	res := make([]*catalogue.DeploymentFlavour, 0, len(r.flavours))
+	for _, f := range r.flavours {
+		cp := *f
+		res = append(res, &cp)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].FlavourKey < res[j].FlavourKey
+	})
+	return res
+}
+
+// get returns the flavour with the given key or external id.
+func (r *flavourRegistry) get(keyOrExtID string) (*catalogue.DeploymentFlavour, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if f, ok := r.flavours[keyOrExtID]; ok {
+		cp := *f
+		return &cp, true
+	}
+	for _, f := range r.flavours {
+		if f.FlavourKey == keyOrExtID {
+			cp := *f
+			return &cp, true
+		}
+	}
+	return nil, false
+}
+
+func (r *flavourRegistry) add(flavour *catalogue.DeploymentFlavour) (*catalogue.DeploymentFlavour, error) {
+	if err := validateFlavour(flavour); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range r.flavours {
+		if f.FlavourKey == flavour.FlavourKey {
+			return nil, errors.New(fmt.Sprintf("flavour %s already exists", flavour.FlavourKey))
+		}
+	}
+	cp := *flavour
+	if cp.ExtID == "" {
+		id, err := newFlavourID()
+		if err != nil {
+			return nil, err
+		}
+		cp.ExtID = id
+	}
+	r.flavours[cp.ExtID] = &cp
+	if err := r.save(); err != nil {
+		delete(r.flavours, cp.ExtID)
+		return nil, err
+	}
+	res := cp
+	return &res, nil
+}
+
+func (r *flavourRegistry) update(flavour *catalogue.DeploymentFlavour) (*catalogue.DeploymentFlavour, error) {
+	if err := validateFlavour(flavour); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, ok := r.flavours[flavour.ExtID]
+	if !ok {
+		return nil, &NotFoundError{Kind: "flavour", ID: flavour.ExtID}
+	}
+	cp := *flavour
+	r.flavours[cp.ExtID] = &cp
+	if err := r.save(); err != nil {
+		r.flavours[cp.ExtID] = old
+		return nil, err
+	}
+	res := cp
+	return &res, nil
+}
+
+func (r *flavourRegistry) delete(extID string) error {
+	if extID == defaultFlavour.ExtID {
+		return errors.New(fmt.Sprintf("flavour %s is the default one and cannot be deleted", defaultFlavour.FlavourKey))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, ok := r.flavours[extID]
+	if !ok {
+		return &NotFoundError{Kind: "flavour", ID: extID}
+	}
+	delete(r.flavours, extID)
+	if err := r.save(); err != nil {
+		r.flavours[extID] = old
+		return err
+	}
+	return nil
+}
+
+// save writes the flavours into a temporary file and renames it, so that the file is never half written. The caller
+// must hold the lock.
+func (r *flavourRegistry) save() error {
+	if r.path == "" {
+		return nil
+	}
+	content, err := json.MarshalIndent(r.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+func validateFlavour(flavour *catalogue.DeploymentFlavour) error {
+	if flavour == nil || flavour.FlavourKey == "" {
+		return errors.New("flavour key is mandatory")
+	}
+	if flavour.VCPUs < 0 || flavour.RAM < 0 || flavour.Disk < 0 {
+		return errors.New(fmt.Sprintf("flavour %s has negative resources", flavour.FlavourKey))
+	}
+	return nil
+}
+
+func newFlavourID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// applyFlavour limits the container resources to those of the flavour, zero values mean no limit. RAM is in MB and Disk
+// in GB as for the other vim drivers.
+func applyFlavour(flavour *catalogue.DeploymentFlavour, hostConfig *container.HostConfig) {
+	if flavour.VCPUs > 0 {
+		hostConfig.NanoCPUs = int64(flavour.VCPUs) * 1e9
+	}
+	if flavour.RAM > 0 {
+		hostConfig.Memory = int64(flavour.RAM) * 1024 * 1024
+	}
+	if flavour.Disk > 0 {
+		if hostConfig.StorageOpt == nil {
+			hostConfig.StorageOpt = make(map[string]string)
+		}
+		hostConfig.StorageOpt["size"] = fmt.Sprintf("%dG", flavour.Disk)
+	}
+}
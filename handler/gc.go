@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"docker.io/go-docker"
+	"docker.io/go-docker/api/types"
+	"github.com/op/go-logging"
+	"github.com/openbaton/go-openbaton/catalogue"
+)
+
+// KnownResources tells which docker resources are still referenced by live records of the NFVO.
+type KnownResources interface {
+	// Known returns the ids or names of the referenced containers and networks
+	Known() (map[string]bool, error)
+}
+
+// FileKnownResources reads the known resources from a json file containing an array of ids or names. The file is read
+// again every time, so it can be kept up to date by an external process.
+type FileKnownResources struct {
+	Path string
+}
+
+func (f *FileKnownResources) Known() (map[string]bool, error) {
+	content, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	if err = json.Unmarshal(content, &ids); err != nil {
+		return nil, err
+	}
+	res := make(map[string]bool)
+	for _, id := range ids {
+		res[id] = true
+	}
+	return res, nil
+}
+
+// vimRegistry remembers the vim instances the driver was called for, so that their resources can be collected.
+type vimRegistry struct {
+	mu   sync.RWMutex
+	vims map[string]*catalogue.DockerVimInstance
+}
+
+func newVimRegistry() *vimRegistry {
+	return &vimRegistry{
+		vims: make(map[string]*catalogue.DockerVimInstance),
+	}
+}
+
+func (r *vimRegistry) add(instance *catalogue.DockerVimInstance) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.vims[instance.AuthURL] = instance
+}
+
+func (r *vimRegistry) list() []*catalogue.DockerVimInstance {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	res := make([]*catalogue.DockerVimInstance, 0, len(r.vims))
+	for _, instance := range r.vims {
+		res = append(res, instance)
+	}
+	return res
+}
+
+// GarbageCollector periodically looks for containers and networks created by the driver that are not known anymore,
+// and removes them once they have been orphans for the grace period.
+type GarbageCollector struct {
+	Handler     *PluginImpl
+	Known       KnownResources
+	Interval    time.Duration
+	GracePeriod time.Duration
+	// DryRun only reports the orphans without removing them
+	DryRun bool
+	Logger *logging.Logger
+
+	mu sync.Mutex
+	// orphans maps the ids of the orphans to when they were first seen
+	orphans map[string]time.Time
+}
+
+// Run collects every Interval until stop is closed.
+func (gc *GarbageCollector) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(gc.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			gc.Collect(time.Now())
+		}
+	}
+}
+
+// Collect runs one collection for all the vim instances the driver was called for.
+func (gc *GarbageCollector) Collect(now time.Time) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if gc.orphans == nil {
+		gc.orphans = make(map[string]time.Time)
+	}
+	if err := gc.Handler.checkState(); err != nil {
+		gc.Logger.Errorf("Not collecting garbage: %v", err)
+		return
+	}
+	// without knowing what is referenced everything would look orphan, better skip
+	known, err := gc.Known.Known()
+	if err != nil {
+		gc.Logger.Errorf("Not collecting garbage, error reading the known resources: %v", err)
+		return
+	}
+	seen := make(map[string]bool)
+	for _, instance := range gc.Handler.state.vims.list() {
+		h, done := gc.Handler.begin(OperationList)
+		cl, err := h.getClient(instance)
+		done(&err)
+		if err != nil {
+			gc.Logger.Errorf("Error getting client for vim instance %s: %v", instance.Name, err)
+			continue
+		}
+		gc.collectContainers(cl, instance, known, seen, now)
+		gc.collectNetworks(cl, instance, known, seen, now)
+	}
+	for id := range gc.orphans {
+		if !seen[id] {
+			delete(gc.orphans, id)
+		}
+	}
+}
+
+// isOrphan records the resource as orphan and tells whether it has been one for the grace period.
+func (gc *GarbageCollector) isOrphan(id, name, kind string, known, seen map[string]bool, now time.Time) bool {
+	if known[id] || known[name] {
+		return false
+	}
+	seen[id] = true
+	first, ok := gc.orphans[id]
+	if !ok {
+		gc.Logger.Warningf("Found orphan %s %s [%s]", kind, name, id)
+		gc.orphans[id] = now
+		first = now
+	}
+	return now.Sub(first) >= gc.GracePeriod
+}
+
+func (gc *GarbageCollector) collectContainers(cl *docker.Client, instance *catalogue.DockerVimInstance, known, seen map[string]bool, now time.Time) {
+	h, done := gc.Handler.begin(OperationList)
+	defer done(nil)
+	containers, err := cl.ContainerList(h.ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: h.getOwnedFilter(),
+	})
+	if err != nil {
+		gc.Logger.Errorf("Error listing containers of vim instance %s: %v", instance.Name, err)
+		return
+	}
+	for _, c := range containers {
+		name := ""
+		if len(c.Names) > 0 {
+			name = c.Names[0][1:]
+		}
+		if !gc.isOrphan(c.ID, name, "container", known, seen, now) {
+			continue
+		}
+		if gc.DryRun {
+			gc.Logger.Noticef("Would remove orphan container %s [%s]", name, c.ID)
+			continue
+		}
+		gc.Logger.Noticef("Removing orphan container %s [%s]", name, c.ID)
+		if err = h.DeleteServerByIDAndWait(instance, c.ID); err != nil && !IsNotFound(err) {
+			gc.Logger.Errorf("Error removing orphan container [%s]: %v", c.ID, err)
+			continue
+		}
+		delete(gc.orphans, c.ID)
+	}
+}
+
+func (gc *GarbageCollector) collectNetworks(cl *docker.Client, instance *catalogue.DockerVimInstance, known, seen map[string]bool, now time.Time) {
+	h, done := gc.Handler.begin(OperationList)
+	defer done(nil)
+	nets, err := cl.NetworkList(h.ctx, types.NetworkListOptions{
+		Filters: h.getOwnedFilter(),
+	})
+	if err != nil {
+		gc.Logger.Errorf("Error listing networks of vim instance %s: %v", instance.Name, err)
+		return
+	}
+	for _, n := range nets {
+		if !gc.isOrphan(n.ID, n.Name, "network", known, seen, now) {
+			continue
+		}
+		if gc.DryRun {
+			gc.Logger.Noticef("Would remove orphan network %s [%s]", n.Name, n.ID)
+			continue
+		}
+		gc.Logger.Noticef("Removing orphan network %s [%s]", n.Name, n.ID)
+		// containers still attached are either known or collected first, so the network is not forced
+		deleter, deleteDone := gc.Handler.begin(OperationDelete)
+		err = cl.NetworkRemove(deleter.ctx, n.ID)
+		deleteDone(&err)
+		if err != nil {
+			gc.Logger.Errorf("Error removing orphan network [%s]: %v", n.ID, err)
+			continue
+		}
+		delete(gc.orphans, n.ID)
+	}
+}
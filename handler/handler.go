@@ -2,7 +2,6 @@ package handler
 
 import (
 	"context"
-	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -11,115 +10,203 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"io/ioutil"
-	"math/rand"
-
 	"docker.io/go-docker"
 	"docker.io/go-docker/api"
 	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/container"
 	"docker.io/go-docker/api/types/filters"
+	"docker.io/go-docker/api/types/mount"
 	dockerNetwork "docker.io/go-docker/api/types/network"
-	"github.com/docker/go-connections/tlsconfig"
+	"github.com/docker/go-connections/sockets"
 	"github.com/op/go-logging"
 	"github.com/openbaton/go-openbaton/catalogue"
 	"github.com/openbaton/go-openbaton/pluginsdk"
 	"github.com/openbaton/go-openbaton/sdk"
 )
 
-var dockerSecDir = "docker_sec"
+// authorizedKeysPathKey is the vim instance metadata key overriding where the public keys are written in the containers
+const authorizedKeysPathKey = "authorized-keys-path"
+
+var defaultAuthorizedKeysPath = "/root/.ssh/authorized_keys"
+
+const (
+	// stopGracePeriodKey is the vim instance metadata key with the seconds a container has to stop before being killed
+	stopGracePeriodKey = "stop-grace-period"
+	// removeVolumesKey is the vim instance metadata key telling whether anonymous volumes are removed with the container
+	removeVolumesKey = "remove-volumes"
+)
+
+var defaultStopGracePeriod = 10 * time.Second
+
+// launchPollInterval is how often the state of a container is polled while waiting for it, until the timeout of the
+// operation expires
+const launchPollInterval = 1 * time.Second
 
+// PluginImpl is safe for concurrent use by the plugin workers. Its methods work on copies of it, so the state changing
+// over time lives in the handlerState shared by all the copies, while the other fields are set once at startup and
+// only read afterwards. Each operation gets its own context, see begin.
 type PluginImpl struct {
 	Logger        *logging.Logger
 	ctx           context.Context
-	cl            map[string]*docker.Client
 	Swarm         bool
 	Tsl           bool
 	CertDirectory string
+	// KnownHosts is the known_hosts file verifying the keys of ssh docker hosts
+	KnownHosts string
+	// DriverID is set as label on the resources created by the driver, to tell them apart from those of other drivers
+	DriverID string
+	// Timeouts of the operations, DefaultTimeouts are used for the missing ones
+	Timeouts map[Operation]time.Duration
+	// state is nil for handlers not created by NewHandlerPlugin, see checkState
+	state *handlerState
+}
+
+// handlerState holds what the driver keeps across operations, each part synchronising its own access.
+type handlerState struct {
+	clients  *clientManager
+	tracker  *launchTracker
+	flavours *flavourRegistry
+	vims     *vimRegistry
 }
 
 func NewHandlerPlugin(swarm bool) *PluginImpl {
 	return &PluginImpl{
 		Logger: sdk.GetLogger("HandlerPlugin", "DEBUG"),
+		ctx:    context.Background(),
 		Swarm:  swarm,
+		state:  newHandlerState(),
 	}
 }
 
-func (h *PluginImpl) getClient(instance *catalogue.DockerVimInstance) (*docker.Client, error) {
-	var dir string
-	if instance.Ca != "" {
-		var err error
-		if !exists(dockerSecDir) {
-			dir, err = ioutil.TempDir(dockerSecDir, "")
-		}
-		if err != nil {
-			h.Logger.Errorf("Error creating temp dir")
-			return nil, err
-		}
-		err = ioutil.WriteFile(fmt.Sprintf("%s/ca.pem", dir), []byte(instance.Ca), os.ModePerm)
-		err = ioutil.WriteFile(fmt.Sprintf("%s/cert.pem", dir), []byte(instance.Ca), os.ModePerm)
-		err = ioutil.WriteFile(fmt.Sprintf("%s/key.pem", dir), []byte(instance.Ca), os.ModePerm)
+func newHandlerState() *handlerState {
+	return &handlerState{
+		clients:  newClientManager(),
+		tracker:  newLaunchTracker(),
+		flavours: newFlavourRegistry(),
+		vims:     newVimRegistry(),
 	}
+}
 
-	if h.ctx == nil {
-		h.ctx = context.Background()
+// errNoState is returned by the handlers not created by NewHandlerPlugin, which have nowhere to keep the clients,
+// flavours and launches across operations.
+var errNoState = errors.New("the handler was not created with NewHandlerPlugin")
+
+// checkState fails if the handler has no state. The methods work on copies of the handler, so a state cannot be
+// created for it when first needed.
+func (h PluginImpl) checkState() error {
+	if h.state == nil {
+		return errNoState
 	}
+	return nil
+}
 
-	if h.cl == nil {
-		h.cl = make(map[string]*docker.Client)
+// LoadFlavours reads the flavours from the json file at path, if it exists, and keeps it up to date when flavours are
+// added, updated or deleted.
+func (h *PluginImpl) LoadFlavours(path string) error {
+	if err := h.checkState(); err != nil {
+		return err
 	}
+	return h.state.flavours.load(path)
+}
 
-	if _, ok := h.cl[instance.AuthURL]; !ok {
-		var cli *docker.Client
-		var err error
-		if strings.HasPrefix(instance.AuthURL, "unix:") {
-			cli, err = docker.NewClient(instance.AuthURL, api.DefaultVersion, nil, nil)
-		} else {
-			var tlsc *tls.Config
-			if h.Tsl {
-
-				options := tlsconfig.Options{
-					CAFile:             filepath.Join(dir, "ca.pem"),
-					CertFile:           filepath.Join(dir, "cert.pem"),
-					KeyFile:            filepath.Join(dir, "key.pem"),
-					InsecureSkipVerify: false,
-				}
-				tlsc, err = tlsconfig.Client(options)
-				if err != nil {
-					return nil, err
-				}
-			}
-			httpClient := &http.Client{
-				Transport: &http.Transport{
-					TLSClientConfig: tlsc,
-				},
-				CheckRedirect: docker.CheckRedirect,
+func (h *PluginImpl) getClient(instance *catalogue.DockerVimInstance) (*docker.Client, error) {
+	if err := h.checkState(); err != nil {
+		return nil, err
+	}
+	h.state.vims.add(instance)
+	cl, err := h.state.clients.get(h.ctx, instance, h.Logger, func(dialed func(net.Conn, error) (net.Conn, error)) (*docker.Client, io.Closer, error) {
+		return h.newClient(instance, dialed)
+	})
+	if err != nil {
+		h.Logger.Errorf("Error getting client for vim instance %s: %v", instance.Name, err)
+		return nil, err
+	}
+	return cl, nil
+}
+
+// newClient creates a client for the docker host of instance, passing every connection to dialed. For ssh docker hosts
+// the ssh connection is returned as well, to be closed with the client.
+func (h *PluginImpl) newClient(instance *catalogue.DockerVimInstance, dialed func(net.Conn, error) (net.Conn, error)) (*docker.Client, io.Closer, error) {
+	parts := strings.SplitN(instance.AuthURL, "://", 2)
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("invalid docker host %s", instance.AuthURL)
+	}
+	host := instance.AuthURL
+	transport := &http.Transport{}
+	var closer io.Closer
+	if parts[0] == "ssh" {
+		dialer, err := newSSHDialer(instance, h.KnownHosts)
+		if err != nil {
+			return nil, nil, err
+		}
+		// the requests go to the remote socket through the tunnel
+		host = "unix://" + dialer.socket
+		transport.DisableCompression = true
+		transport.Dial = dialer.dial
+		closer = dialer
+	} else {
+		if err := sockets.ConfigureTransport(transport, parts[0], parts[1]); err != nil {
+			return nil, nil, err
+		}
+		if parts[0] != "unix" {
+			tlsc, err := h.getTLSConfig(instance)
+			if err != nil {
+				return nil, nil, err
 			}
-			cli, err = docker.NewClient(instance.AuthURL, api.DefaultVersion, httpClient, nil)
-			os.RemoveAll(dir)
+			transport.TLSClientConfig = tlsc
 		}
-		if err != nil {
-			panic(err)
+	}
+	if transport.TLSClientConfig != nil {
+		// the transport still needs the configuration to use https
+		transport.DialTLS = trackingDial(tlsDial(transport.Dial, transport.TLSClientConfig), dialed)
+	}
+	transport.Dial = trackingDial(transport.Dial, dialed)
+	httpClient := &http.Client{
+		Transport:     transport,
+		CheckRedirect: docker.CheckRedirect,
+	}
+	version, err := getAPIVersion(instance)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version == "" {
+		version = api.DefaultVersion
+	}
+	cl, err := docker.NewClient(host, version, httpClient, nil)
+	if err != nil {
+		if closer != nil {
+			closer.Close()
 		}
-		h.cl[instance.AuthURL] = cli
+		return nil, nil, err
 	}
-
-	return h.cl[instance.AuthURL], nil
-
+	return cl, closer, nil
 }
 
 func (h PluginImpl) AddFlavour(vimInstance interface{}, deploymentFlavour *catalogue.DeploymentFlavour) (*catalogue.DeploymentFlavour, error) {
-	return deploymentFlavour, nil
+	if err := h.checkState(); err != nil {
+		return nil, err
+	}
+	flavour, err := h.state.flavours.add(deploymentFlavour)
+	if err != nil {
+		h.Logger.Errorf("Error adding flavour: %v", err)
+		return nil, err
+	}
+	h.Logger.Infof("Added flavour [%s] with ext id [%s]", flavour.FlavourKey, flavour.ExtID)
+	return flavour, nil
 }
 
 func (h PluginImpl) AddImage(vimInstance interface{}, image catalogue.BaseImageInt, imageFile []byte) (catalogue.BaseImageInt, error) {
 	return image, nil
 }
 
-func (h PluginImpl) AddImageFromURL(vimInstance interface{}, image catalogue.BaseImageInt, imageURL string) (catalogue.BaseImageInt, error) {
+func (h PluginImpl) AddImageFromURL(vimInstance interface{}, image catalogue.BaseImageInt, imageURL string) (_ catalogue.BaseImageInt, err error) {
+	h, done := h.begin(OperationPull)
+	defer done(&err)
 	dockerVimInstance, err := pluginsdk.GetDockerVimInstance(vimInstance)
 	if err != nil {
 		h.Logger.Errorf("Error getting Docker Vim Instance: %v", err)
@@ -145,11 +232,18 @@ func (h PluginImpl) AddImageFromURL(vimInstance interface{}, image catalogue.Bas
 		return nil, err
 	}
 
-	io.Copy(os.Stdout, out)
 	defer out.Close()
+	// the pull goes on while its progress is read, so reading fails if the deadline of the operation hits
+	if _, err = io.Copy(os.Stdout, out); err != nil {
+		h.Logger.Errorf("Error pulling image %s: %v", imageURL, err)
+		return nil, err
+	}
 
 	img, err := getImagesByName(cl, h.ctx, imageURL)
-
+	if err != nil {
+		h.Logger.Errorf("Error getting the pulled image %s: %v", imageURL, err)
+		return nil, err
+	}
 	h.Logger.Debugf("New Tags are: %v", img[0].RepoTags)
 	if len(img) == 1 {
 		var extId string
@@ -201,7 +295,9 @@ func inc(ip net.IP) {
 	}
 }
 
-func (h PluginImpl) CreateNetwork(vimInstance interface{}, network catalogue.BaseNetworkInt) (catalogue.BaseNetworkInt, error) {
+func (h PluginImpl) CreateNetwork(vimInstance interface{}, network catalogue.BaseNetworkInt) (_ catalogue.BaseNetworkInt, err error) {
+	h, done := h.begin(OperationCreate)
+	defer done(&err)
 	dockerVimInstance, err := pluginsdk.GetDockerVimInstance(vimInstance)
 	if err != nil {
 		h.Logger.Errorf("Error getting Docker Vim Instance: %v", err)
@@ -217,50 +313,58 @@ func (h PluginImpl) CreateNetwork(vimInstance interface{}, network catalogue.Bas
 		h.Logger.Errorf("Error getting the client: %v", err)
 		return nil, err
 	}
-	var driver string
-	if h.Swarm {
-		driver = "overlay"
-	} else if val, ok := dockerNet.Metadata["driver"]; ok {
-		driver = val
-	} else {
-		driver = "bridge"
-	}
-
-	var ipam = &dockerNetwork.IPAM{}
-	if val, ok := dockerNet.Metadata["ipam-driver"]; ok {
-		ipam.Driver = val
+	netCreateOpt, err := h.getNetworkCreate(dockerVimInstance, dockerNet)
+	if err != nil {
+		h.Logger.Errorf("Error parsing network: %v", err)
+		return nil, err
 	}
-	if dockerNet.Subnet != "" {
-		ipamConfig := make([]dockerNetwork.IPAMConfig, 1)
-		ipamConfig[0].Subnet = dockerNet.Subnet
-		ip, _, err := net.ParseCIDR(dockerNet.Subnet)
-		if err != nil {
-			debug.PrintStack()
-			return nil, err
-		}
-		inc(ip)
-		ipamConfig[0].Gateway = ip.String()
-		ipam.Config = ipamConfig
+	if err = h.checkNetworkFeatures(cl, netCreateOpt); err != nil {
+		h.Logger.Errorf("Not able to create network %s: %v", dockerNet.Name, err)
+		return nil, err
 	}
 	h.Logger.Debugf("Received DockerNetwork %+v", dockerNet)
-	dockerNet.Name = fmt.Sprintf("%s_%d", dockerNet.Name, 9999-rand.Intn(9000))
-	for ok, err := existsNetwork(cl, h.ctx, dockerNet.Name); ok; ok, err = existsNetwork(cl, h.ctx, dockerNet.Name) {
-
+	if dockerNet.Name, err = getNetworkName(dockerNet); err != nil {
+		h.Logger.Errorf("Error naming network: %v", err)
+		return nil, err
+	}
+	// the same logical network was already created for the same records, probably by a retry of the NFVO
+	retry := hasRecordIDs(dockerNet)
+	if retry {
+		existing, err := getNetworkByName(cl, h.ctx, dockerNet.Name)
 		if err != nil {
 			h.Logger.Errorf("Not able to list network with name %s: %v", dockerNet.Name, err)
 			return nil, err
 		}
-		dockerNet.Name = fmt.Sprintf("%s_%d", strings.Split(dockerNet.Name, "_")[0], 9999-rand.Intn(9000))
-		ok, err = existsNetwork(cl, h.ctx, dockerNet.Name)
+		if existing != nil {
+			obNet, err := h.getExistingNetwork(*existing, netCreateOpt)
+			if err != nil {
+				h.Logger.Errorf("Not able to create network [%s]: %v", dockerNet.Name, err)
+				return nil, err
+			}
+			return obNet, nil
+		}
 	}
 
-	netCreateOpt := types.NetworkCreate{
-		IPAM:   ipam,
-		Driver: driver,
+	if isHostInterfaceDriver(netCreateOpt.Driver) {
+		if err = h.validateParent(cl, dockerVimInstance, netCreateOpt, ""); err != nil {
+			h.Logger.Errorf("Not able to create network [%s]: %v", dockerNet.Name, err)
+			return nil, err
+		}
 	}
 	h.Logger.Debugf("Creating network [%s] with config %v", dockerNet.Name, netCreateOpt)
 	resp, err := cl.NetworkCreate(h.ctx, dockerNet.Name, netCreateOpt)
 	if err != nil {
+		// a parallel retry may have created it in the meantime
+		if retry {
+			if existing, _ := getNetworkByName(cl, h.ctx, dockerNet.Name); existing != nil {
+				obNet, err := h.getExistingNetwork(*existing, netCreateOpt)
+				if err != nil {
+					h.Logger.Errorf("Not able to create network [%s]: %v", dockerNet.Name, err)
+					return nil, err
+				}
+				return obNet, nil
+			}
+		}
 		h.Logger.Errorf("Error creating network: %v", err)
 		return nil, err
 	}
@@ -276,27 +380,112 @@ func (h PluginImpl) CreateNetwork(vimInstance interface{}, network catalogue.Bas
 	}
 	return obNet, nil
 }
-func existsNetwork(cl *docker.Client, ctx context.Context, name string) (bool, error) {
+
+// getNetworkByName returns nil if there is no network with exactly that name, the name filter of docker matches also
+// parts of the name.
+func getNetworkByName(cl *docker.Client, ctx context.Context, name string) (*types.NetworkResource, error) {
 	keyValuePair := filters.NewArgs(filters.Arg("name", name))
 	nets, err := cl.NetworkList(ctx, types.NetworkListOptions{
 		Filters: keyValuePair,
 	})
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	for _, n := range nets {
+		if n.Name == name {
+			return &n, nil
+		}
 	}
-	return len(nets) > 0, nil
+	return nil, nil
 }
 
-func (h PluginImpl) CreateSubnet(vimInstance interface{}, createdNetwork catalogue.BaseNetworkInt, subnet *catalogue.Subnet) (*catalogue.Subnet, error) {
-	return subnet, nil
+func (h PluginImpl) CreateSubnet(vimInstance interface{}, createdNetwork catalogue.BaseNetworkInt, subnet *catalogue.Subnet) (_ *catalogue.Subnet, err error) {
+	h, done := h.begin(OperationCreate)
+	defer done(&err)
+	dockerVimInstance, err := pluginsdk.GetDockerVimInstance(vimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting Docker Vim Instance: %v", err)
+		return nil, err
+	}
+	dockerNet, err := getDockerNet(createdNetwork)
+	if err != nil {
+		h.Logger.Errorf("Error getting the Docker Network: %v", err)
+		return nil, err
+	}
+	cl, err := h.getClient(dockerVimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting client: %v", err)
+		return nil, err
+	}
+	old, err := h.getManagedNetwork(cl, dockerNet.ExtID)
+	if err != nil {
+		h.Logger.Errorf("Not able to add subnet to network [%s]: %v", dockerNet.ExtID, err)
+		return nil, err
+	}
+	cidr, err := canonicalCIDR(subnet.CIDR)
+	if err != nil {
+		h.Logger.Errorf("Not able to add subnet to network [%s]: %v", old.Name, err)
+		return nil, err
+	}
+	// the subnet was already added, by CreateNetwork from the subnets of the network or by a retry of the NFVO
+	if pool, ok := findPool(cidr, old.IPAM.Config); ok {
+		res := *subnet
+		res.ExtID = subnetExtID(old.Name, pool.Subnet)
+		res.NetworkID = old.ID
+		res.CIDR = pool.Subnet
+		if pool.Gateway != "" {
+			res.GatewayIP = pool.Gateway
+		}
+		h.Logger.Infof("Subnet %s with ext id [%s] already exists in network [%s]", res.CIDR, res.ExtID, old.Name)
+		return &res, nil
+	}
+	if err = checkOverlap(cidr, old.IPAM.Config); err != nil {
+		h.Logger.Errorf("Not able to add subnet to network [%s]: %v", old.Name, err)
+		return nil, err
+	}
+	gateway := subnet.GatewayIP
+	if gateway == "" {
+		if gateway, err = getGateway(cidr); err != nil {
+			return nil, err
+		}
+	}
+	// the pools of a docker network cannot be changed, so it is recreated with the new one
+	create := networkCreateFromResource(old)
+	create.IPAM.Config = append(create.IPAM.Config, dockerNetwork.IPAMConfig{
+		Subnet:  cidr,
+		Gateway: gateway,
+	})
+	create.EnableIPv6 = create.EnableIPv6 || isIPv6Subnet(cidr)
+	dockNet, err := h.recreateNetwork(cl, old, create)
+	if err != nil {
+		h.Logger.Errorf("Error adding subnet %s to network [%s]: %v", cidr, old.Name, err)
+		return nil, err
+	}
+	res := *subnet
+	res.ExtID = subnetExtID(dockNet.Name, cidr)
+	res.CIDR = cidr
+	res.NetworkID = dockNet.ID
+	res.GatewayIP = gateway
+	h.Logger.Infof("Added subnet %s with ext id [%s] to network [%s]", res.CIDR, res.ExtID, dockNet.Name)
+	return &res, nil
 }
 func (h PluginImpl) DeleteFlavour(vimInstance interface{}, extID string) (bool, error) {
+	if err := h.checkState(); err != nil {
+		return false, err
+	}
+	if err := h.state.flavours.delete(extID); err != nil {
+		h.Logger.Errorf("Error deleting flavour: %v", err)
+		return false, err
+	}
+	h.Logger.Infof("Deleted flavour [%s]", extID)
 	return true, nil
 }
 func (h PluginImpl) DeleteImage(vimInstance interface{}, image catalogue.BaseImageInt) (bool, error) {
 	return true, nil
 }
-func (h PluginImpl) DeleteNetwork(vimInstance interface{}, extID string) (bool, error) {
+func (h PluginImpl) DeleteNetwork(vimInstance interface{}, extID string) (_ bool, err error) {
+	h, done := h.begin(OperationDelete)
+	defer done(&err)
 	h.Logger.Debugf("Deleting network [%s]", extID)
 	dockerVimInstance, err := pluginsdk.GetDockerVimInstance(vimInstance)
 	if err != nil {
@@ -308,7 +497,38 @@ func (h PluginImpl) DeleteNetwork(vimInstance interface{}, extID string) (bool,
 		h.Logger.Errorf("Error getting client: %v", err)
 		return false, err
 	}
-	err = cl.NetworkRemove(h.ctx, extID)
+	policy := DeletePolicyRefuse
+	if val, ok := dockerVimInstance.Metadata[networkDeletePolicyKey]; ok {
+		if val != DeletePolicyRefuse && val != DeletePolicyDetach {
+			return false, errors.New(fmt.Sprintf("unknown %s %s, use %s or %s", networkDeletePolicyKey, val, DeletePolicyRefuse, DeletePolicyDetach))
+		}
+		policy = val
+	}
+	dockNet, err := h.getManagedNetwork(cl, extID)
+	if err != nil {
+		h.Logger.Errorf("Not able to delete network [%s]: %v", extID, err)
+		return false, err
+	}
+	if len(dockNet.Containers) > 0 {
+		if policy == DeletePolicyRefuse {
+			containers := make([]string, 0, len(dockNet.Containers))
+			for id, endpoint := range dockNet.Containers {
+				containers = append(containers, fmt.Sprintf("%s (%s)", endpoint.Name, id))
+			}
+			sort.Strings(containers)
+			err = &NetworkInUseError{Network: dockNet.Name, Containers: containers}
+			h.Logger.Errorf("Not able to delete network [%s]: %v", extID, err)
+			return false, err
+		}
+		for id := range dockNet.Containers {
+			h.Logger.Debugf("Disconnecting container [%s] from network [%s]", id, dockNet.Name)
+			if err = cl.NetworkDisconnect(h.ctx, dockNet.ID, id, true); err != nil {
+				h.Logger.Errorf("Error disconnecting container [%s]: %v", id, err)
+				return false, err
+			}
+		}
+	}
+	err = cl.NetworkRemove(h.ctx, dockNet.ID)
 	if err != nil {
 		h.Logger.Errorf("Error Deleting network: %v", err)
 		return false, err
@@ -316,82 +536,166 @@ func (h PluginImpl) DeleteNetwork(vimInstance interface{}, extID string) (bool,
 	h.Logger.Infof("Deleted network [%s]", extID)
 	return true, nil
 }
-func (h PluginImpl) DeleteServerByIDAndWait(vimInstance interface{}, id string) error {
-	return nil
-}
-func (h PluginImpl) DeleteSubnet(vimInstance interface{}, existingSubnetExtID string) (bool, error) {
-	return true, nil
-}
-func (h PluginImpl) LaunchInstance(vimInstance interface{}, name, image, Flavour, keypair string, network []*catalogue.VNFDConnectionPoint, secGroup []string, userData string) (*catalogue.Server, error) {
-	srv := &catalogue.Server{}
-	return srv, nil
-}
-func (h PluginImpl) LaunchInstanceAndWait(vimInstance interface{}, hostname, image, flavorKey, keyPair string, network []*catalogue.VNFDConnectionPoint, securityGroups []string, userdata string) (*catalogue.Server, error) {
-	if userdata != "" {
-		h.Logger.Warning("User-data is IGNORED, why did you pass it?!")
-	}
-	srv := &catalogue.Server{}
-	return srv, nil
-}
-func (h PluginImpl) LaunchInstanceAndWaitWithIPs(vimInstance interface{}, hostname, image, extID, keyPair string, network []*catalogue.VNFDConnectionPoint, securityGroups []string, userdata string, floatingIps map[string]string, keys []*catalogue.Key) (*catalogue.Server, error) {
-
-	return h.LaunchInstanceAndWait(vimInstance, hostname, image, extID, keyPair, network, securityGroups, userdata)
-}
-func (h PluginImpl) ListFlavours(vimInstance interface{}) ([]*catalogue.DeploymentFlavour, error) {
+func (h PluginImpl) DeleteServerByIDAndWait(vimInstance interface{}, id string) (err error) {
+	h, done := h.begin(OperationDelete)
+	defer done(&err)
+	h.Logger.Debugf("Deleting container [%s]", id)
 	dockerVimInstance, err := pluginsdk.GetDockerVimInstance(vimInstance)
 	if err != nil {
-		h.Logger.Errorf("Error getting Docker Vim Instance: %v", err)
-		return nil, err
+		h.Logger.Errorf("Error getting docker vim instance: %v", err)
+		return err
 	}
-	_, err = h.getClient(dockerVimInstance)
+	cl, err := h.getClient(dockerVimInstance)
 	if err != nil {
 		h.Logger.Errorf("Error getting client: %v", err)
-		return nil, err
+		return err
+	}
+	c, err := cl.ContainerInspect(h.ctx, id)
+	if err != nil {
+		if docker.IsErrNotFound(err) {
+			return &NotFoundError{Kind: "container", ID: id}
+		}
+		h.Logger.Errorf("Error inspecting container: %v", err)
+		return err
+	}
+	gracePeriod, err := getStopGracePeriod(dockerVimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting the stop grace period: %v", err)
+		return err
+	}
+	if c.State.Running {
+		h.Logger.Debugf("Stopping container [%s] with a grace period of %v", id, gracePeriod)
+		if err = cl.ContainerStop(h.ctx, id, &gracePeriod); err != nil {
+			h.Logger.Errorf("Error stopping container: %v", err)
+			return err
+		}
+	}
+	for netName, endpoint := range c.NetworkSettings.Networks {
+		if isPredefinedNetwork(netName) {
+			continue
+		}
+		netRes, err := cl.NetworkInspect(h.ctx, endpoint.NetworkID, types.NetworkInspectOptions{})
+		if err != nil || !h.isOwned(netRes.Labels) {
+			continue
+		}
+		if err = cl.NetworkDisconnect(h.ctx, endpoint.NetworkID, id, true); err != nil {
+			h.Logger.Warningf("Error disconnecting container [%s] from network [%s]: %v", id, netName, err)
+		}
+	}
+	err = cl.ContainerRemove(h.ctx, id, types.ContainerRemoveOptions{
+		RemoveVolumes: dockerVimInstance.Metadata[removeVolumesKey] == "true",
+		Force:         true,
+	})
+	if err != nil && !docker.IsErrNotFound(err) {
+		h.Logger.Errorf("Error removing container: %v", err)
+		return err
+	}
+	if err = h.waitForRemoval(cl, id); err != nil {
+		h.Logger.Errorf("Error waiting for the removal of container [%s]: %v", id, err)
+		return err
 	}
+	h.state.tracker.forget(id)
+	h.Logger.Infof("Deleted container [%s]", id)
+	return nil
+}
 
-	res := make([]*catalogue.DeploymentFlavour, 1)
+// getStopGracePeriod returns the time a container of the vim instance has to stop before being killed.
+func getStopGracePeriod(instance *catalogue.DockerVimInstance) (time.Duration, error) {
+	val, ok := instance.Metadata[stopGracePeriodKey]
+	if !ok {
+		return defaultStopGracePeriod, nil
+	}
+	seconds, err := strconv.Atoi(val)
+	if err != nil || seconds < 0 {
+		return 0, errors.New(fmt.Sprintf("metadata %s must be a number of seconds, not [%s]", stopGracePeriodKey, val))
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
 
-	res[0] = &catalogue.DeploymentFlavour{
-		ExtID:      "12345",
-		FlavourKey: "m1.small",
-		Disk:       0,
-		RAM:        0,
-		VCPUs:      0,
+// getAuthorizedKeysPath returns the file inside the containers of the vim instance where the public keys are written.
+func getAuthorizedKeysPath(instance *catalogue.DockerVimInstance) string {
+	if val, ok := instance.Metadata[authorizedKeysPathKey]; ok && val != "" {
+		return val
+	}
+	return defaultAuthorizedKeysPath
+}
+
+// waitForRemoval polls the docker API until the container does not exist anymore, or the operation is cancelled.
+func (h PluginImpl) waitForRemoval(cl *docker.Client, containerId string) error {
+	ticker := time.NewTicker(launchPollInterval)
+	defer ticker.Stop()
+	for {
+		_, err := cl.ContainerInspect(h.ctx, containerId)
+		if docker.IsErrNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case <-h.ctx.Done():
+			return errors.New(fmt.Sprintf("container %s still exists: %v", containerId, h.ctx.Err()))
+		case <-ticker.C:
+		}
 	}
-	return res, nil
 }
-func (h PluginImpl) ListImages(vimInstance interface{}) (catalogue.BaseImageInt, error) {
+func (h PluginImpl) DeleteSubnet(vimInstance interface{}, existingSubnetExtID string) (_ bool, err error) {
+	h, done := h.begin(OperationDelete)
+	defer done(&err)
 	dockerVimInstance, err := pluginsdk.GetDockerVimInstance(vimInstance)
 	if err != nil {
 		h.Logger.Errorf("Error getting Docker Vim Instance: %v", err)
-		return nil, err
+		return false, err
 	}
 	cl, err := h.getClient(dockerVimInstance)
 	if err != nil {
 		h.Logger.Errorf("Error getting client: %v", err)
-		return nil, err
+		return false, err
 	}
-	opt := types.ImageListOptions{}
-	images, err := cl.ImageList(h.ctx, opt)
+	nets, err := cl.NetworkList(h.ctx, types.NetworkListOptions{
+		Filters: h.getOwnedFilter(),
+	})
 	if err != nil {
-		h.Logger.Errorf("Error listing images: %v", err)
-		return nil, err
+		h.Logger.Errorf("Error listing networks: %v", err)
+		return false, err
 	}
-
-	res := make([]*catalogue.DockerImage, len(images))
-
-	for index, img := range images {
-		nfvImg, err := GetImage(img)
-		if err != nil {
-			h.Logger.Errorf("Error translating image: %v", err)
-			return nil, err
+	for _, n := range nets {
+		for i, pool := range n.IPAM.Config {
+			if subnetExtID(n.Name, pool.Subnet) != existingSubnetExtID {
+				continue
+			}
+			if len(n.IPAM.Config) == 1 {
+				return false, errors.New(fmt.Sprintf("subnet %s is the only one of network %s", pool.Subnet, n.Name))
+			}
+			// the list does not contain the attached containers
+			old, err := cl.NetworkInspect(h.ctx, n.ID, types.NetworkInspectOptions{})
+			if err != nil {
+				h.Logger.Errorf("Error inspecting network: %v", err)
+				return false, err
+			}
+			_, cidr, _ := net.ParseCIDR(pool.Subnet)
+			for containerId, endpoint := range old.Containers {
+				for _, addr := range []string{endpoint.IPv4Address, endpoint.IPv6Address} {
+					if ip, _, err := net.ParseCIDR(addr); err == nil && cidr != nil && cidr.Contains(ip) {
+						return false, errors.New(fmt.Sprintf("subnet %s is used by container %s", pool.Subnet, containerId))
+					}
+				}
+			}
+			create := networkCreateFromResource(old)
+			create.IPAM.Config = append(create.IPAM.Config[:i:i], create.IPAM.Config[i+1:]...)
+			if _, err = h.recreateNetwork(cl, old, create); err != nil {
+				h.Logger.Errorf("Error removing subnet %s from network [%s]: %v", pool.Subnet, n.Name, err)
+				return false, err
+			}
+			h.Logger.Infof("Deleted subnet %s of network [%s]", pool.Subnet, n.Name)
+			return true, nil
 		}
-		res[index] = nfvImg
 	}
-	h.Logger.Infof("Listed %d images", len(res))
-	return res, nil
+	return false, &NotFoundError{Kind: "subnet", ID: existingSubnetExtID}
 }
-func (h PluginImpl) ListNetworks(vimInstance interface{}) (catalogue.BaseNetworkInt, error) {
+func (h PluginImpl) LaunchInstance(vimInstance interface{}, name, image, Flavour, keypair string, network []*catalogue.VNFDConnectionPoint, secGroup []string, userData string) (_ *catalogue.Server, err error) {
+	h, done := h.begin(OperationCreate)
+	defer done(&err)
 	dockerVimInstance, err := pluginsdk.GetDockerVimInstance(vimInstance)
 	if err != nil {
 		h.Logger.Errorf("Error getting Docker Vim Instance: %v", err)
@@ -402,58 +706,479 @@ func (h PluginImpl) ListNetworks(vimInstance interface{}) (catalogue.BaseNetwork
 		h.Logger.Errorf("Error getting client: %v", err)
 		return nil, err
 	}
-	opt := types.NetworkListOptions{}
-	networksDock, err := cl.NetworkList(h.ctx, opt)
+	containerId, err := h.createAndStartContainer(cl, &launchOptions{
+		hostname: name,
+		image:    image,
+		flavour:  Flavour,
+		network:  network,
+		userdata: userData,
+		labels:   h.getOwnerLabels(dockerVimInstance, nil),
+	})
 	if err != nil {
-		h.Logger.Errorf("Error listing networks: %v", err)
 		return nil, err
 	}
+	// the wait goes on after returning, so it gets a timeout of its own
+	waiter, waitDone := h.begin(OperationCreate)
+	h.state.tracker.track(h.Logger, cl, containerId, func(cl *docker.Client, containerId string) (err error) {
+		defer waitDone(&err)
+		return waiter.waitForRunning(cl, containerId)
+	})
+	h.Logger.Infof("Launched container [%s] with id [%s], not waiting for it", name, containerId)
+	return &catalogue.Server{
+		Name:           name,
+		HostName:       name,
+		InstanceName:   name,
+		ExtID:          containerId,
+		Status:         StatusBuild,
+		ExtendedStatus: StatusBuild,
+	}, nil
+}
+func (h PluginImpl) LaunchInstanceAndWait(vimInstance interface{}, hostname, image, flavorKey, keyPair string, network []*catalogue.VNFDConnectionPoint, securityGroups []string, userdata string) (*catalogue.Server, error) {
+	return h.launchAndWait(vimInstance, &launchOptions{
+		hostname: hostname,
+		image:    image,
+		flavour:  flavorKey,
+		network:  network,
+		userdata: userdata,
+	})
+}
 
-	res := make([]*catalogue.DockerNetwork, len(networksDock))
-
-	for index, netDock := range networksDock {
-		obNet, err := GetNetwork(netDock)
-		if err != nil {
-			h.Logger.Errorf("Error translating image: %v", err)
-			return nil, err
-		}
-		res[index] = obNet
-	}
-	h.Logger.Infof("Listed %d networks", len(res))
-	h.Logger.Debugf("Networks are %s", func() []string {
-		v := make([]string, len(res))
-		for i, n := range res {
-			v[i] = n.Name
-		}
-		return v
-	}())
-	return res, nil
+// launchOptions collects what is needed to create a container out of the LaunchInstance* arguments.
+type launchOptions struct {
+	hostname string
+	image    string
+	// flavour is the key or external id of the flavour limiting the container resources
+	flavour  string
+	network  []*catalogue.VNFDConnectionPoint
+	userdata string
+	// ips maps network names or ids to the static address the container gets on that network
+	ips map[string]string
+	// keys are the public keys written to keysPath in the container
+	keys     []*catalogue.Key
+	keysPath string
+	// labels are set on the container in addition to the flavour one
+	labels map[string]string
 }
 
-func (h PluginImpl) Refresh(vimInstance interface{}) (interface{}, error) {
+func (h PluginImpl) launchAndWait(vimInstance interface{}, opts *launchOptions) (_ *catalogue.Server, err error) {
+	h, done := h.begin(OperationCreate)
+	defer done(&err)
 	dockerVimInstance, err := pluginsdk.GetDockerVimInstance(vimInstance)
 	if err != nil {
 		h.Logger.Errorf("Error getting Docker Vim Instance: %v", err)
 		return nil, err
 	}
-	//Images
-	imgs, err := h.ListImages(vimInstance)
+	cl, err := h.getClient(dockerVimInstance)
 	if err != nil {
-		h.Logger.Errorf("Error listing images: %v", err)
+		h.Logger.Errorf("Error getting client: %v", err)
 		return nil, err
 	}
-	imageLen := len(imgs.([]*catalogue.DockerImage))
-	dockerVimInstance.Images = make([]catalogue.DockerImage, imageLen)
-	for i := 0; i < imageLen; i++ {
-		dockerVimInstance.Images[i] = *(imgs.([]*catalogue.DockerImage)[i])
+	opts.labels = h.getOwnerLabels(dockerVimInstance, nil)
+	opts.keysPath = getAuthorizedKeysPath(dockerVimInstance)
+	containerId, err := h.createAndStartContainer(cl, opts)
+	if err != nil {
+		return nil, err
 	}
-	//Networks
-	nets, err := h.ListNetworks(vimInstance)
+	if err = h.waitForRunning(cl, containerId); err != nil {
+		h.Logger.Errorf("Container [%s] did not reach the running state: %v", containerId, err)
+		// otherwise launching it again would fail, as the name is taken
+		h.removeContainer(cl, containerId)
+		return nil, err
+	}
+	srv, err := h.getServer(cl, containerId)
 	if err != nil {
-		h.Logger.Errorf("Error listing networks: %v", err)
+		h.Logger.Errorf("Error translating container: %v", err)
 		return nil, err
 	}
-	netLen := len(nets.([]*catalogue.DockerNetwork))
+	h.Logger.Infof("Launched container [%s] with id [%s]", srv.Name, srv.ExtID)
+	return srv, nil
+}
+
+// createAndStartContainer creates a container named after the hostname, connects it to all the networks referenced by
+// the connection points and starts it. If anything fails after the creation the container is removed again.
+func (h PluginImpl) createAndStartContainer(cl *docker.Client, opts *launchOptions) (string, error) {
+	hostname := opts.hostname
+	endpoints, err := h.getEndpointsConfig(cl, opts.network, opts.ips)
+	if err != nil {
+		return "", err
+	}
+	config := &container.Config{
+		Image:    opts.image,
+		Hostname: hostname,
+		Labels:   make(map[string]string),
+	}
+	for key, val := range opts.labels {
+		config.Labels[key] = val
+	}
+	hostConfig := &container.HostConfig{}
+	if opts.flavour != "" {
+		flavour, ok := h.state.flavours.get(opts.flavour)
+		if !ok {
+			h.Logger.Errorf("Flavour %s not found", opts.flavour)
+			return "", &NotFoundError{Kind: "flavour", ID: opts.flavour}
+		}
+		h.Logger.Debugf("Using flavour %s with %d vcpus, %d MB ram and %d GB disk", flavour.FlavourKey, flavour.VCPUs, flavour.RAM, flavour.Disk)
+		if flavour.VCPUs > 0 {
+			if err = requireVersion(cl, "cpu limits of flavours", nanoCPUsAPIVersion); err != nil {
+				h.Logger.Errorf("Not able to use flavour %s: %v", flavour.FlavourKey, err)
+				return "", err
+			}
+		}
+		applyFlavour(flavour, hostConfig)
+		config.Labels[flavourLabel] = flavour.FlavourKey
+	}
+	userdata, err := parseUserdata(opts.userdata)
+	if err != nil {
+		h.Logger.Errorf("Error parsing user-data: %v", err)
+		return "", err
+	}
+	if userdata == nil && opts.userdata != "" {
+		h.Logger.Warningf("User-data not starting with %s is IGNORED", userdataHeader)
+	}
+	if userdata != nil {
+		if err = h.applyUserdata(cl, config, userdata); err != nil {
+			return "", err
+		}
+	}
+	prepare := func(containerId string) error {
+		// the keys are copied before starting so that they are already there when the ssh daemon comes up
+		if len(opts.keys) > 0 {
+			if err := h.copyAuthorizedKeys(cl, containerId, opts.keys, opts.keysPath); err != nil {
+				h.Logger.Errorf("Error copying keys into container [%s]: %v", hostname, err)
+				return err
+			}
+		}
+		if userdata != nil && userdata.script != nil {
+			archive, err := userdata.getScriptArchive()
+			if err == nil {
+				err = cl.CopyToContainer(h.ctx, containerId, filepath.Dir(filepath.Dir(userdataScriptPath)), archive, types.CopyToContainerOptions{})
+			}
+			if err != nil {
+				h.Logger.Errorf("Error copying user-data into container [%s]: %v", hostname, err)
+				return err
+			}
+		}
+		return nil
+	}
+	return h.runContainer(cl, hostname, config, hostConfig, endpoints, prepare)
+}
+
+// runContainer creates the container, connects it to the networks of the endpoints, calls prepare if not nil and
+// starts the container. If anything fails after the creation the container is removed again.
+func (h PluginImpl) runContainer(cl *docker.Client, name string, config *container.Config, hostConfig *container.HostConfig, endpoints []*dockerNetwork.EndpointSettings, prepare func(string) error) (string, error) {
+	// Docker accepts only one network at creation time, the others are connected before starting the container
+	netConfig := &dockerNetwork.NetworkingConfig{
+		EndpointsConfig: make(map[string]*dockerNetwork.EndpointSettings),
+	}
+	if len(endpoints) > 0 {
+		netConfig.EndpointsConfig[endpoints[0].NetworkID] = endpoints[0]
+	}
+	h.Logger.Debugf("Creating container [%s] from image [%s]", name, config.Image)
+	resp, err := cl.ContainerCreate(h.ctx, config, hostConfig, netConfig, name)
+	if err != nil {
+		h.Logger.Errorf("Error creating container: %v", err)
+		return "", err
+	}
+	for _, warning := range resp.Warnings {
+		h.Logger.Warningf("Container [%s]: %s", name, warning)
+	}
+	for i := 1; i < len(endpoints); i++ {
+		if err = cl.NetworkConnect(h.ctx, endpoints[i].NetworkID, resp.ID, endpoints[i]); err != nil {
+			h.Logger.Errorf("Error connecting container [%s] to network [%s]: %v", name, endpoints[i].NetworkID, err)
+			h.removeContainer(cl, resp.ID)
+			return "", err
+		}
+	}
+	if prepare != nil {
+		if err = prepare(resp.ID); err != nil {
+			h.removeContainer(cl, resp.ID)
+			return "", err
+		}
+	}
+	if err = cl.ContainerStart(h.ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		h.Logger.Errorf("Error starting container: %v", err)
+		h.removeContainer(cl, resp.ID)
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// applyUserdata sets the environment or the entrypoint of the container config depending on the user-data mode.
+func (h PluginImpl) applyUserdata(cl *docker.Client, config *container.Config, userdata *userdataConfig) error {
+	h.Logger.Debugf("Using user-data in mode %s", userdata.mode)
+	switch userdata.mode {
+	case UserdataEnv:
+		config.Env = append(config.Env, userdata.env...)
+	case UserdataEntrypoint:
+		config.Entrypoint = userdata.getEntrypoint(nil, nil)
+	case UserdataInit:
+		// the original entrypoint and command of the image are needed to run them after the script
+		img, _, err := cl.ImageInspectWithRaw(h.ctx, config.Image)
+		if err != nil {
+			h.Logger.Errorf("Error inspecting image %s: %v", config.Image, err)
+			return err
+		}
+		if img.Config == nil {
+			config.Entrypoint = userdata.getEntrypoint(nil, nil)
+		} else {
+			config.Entrypoint = userdata.getEntrypoint(img.Config.Entrypoint, img.Config.Cmd)
+		}
+	}
+	return nil
+}
+
+// getEndpointsConfig resolves the networks referenced by the connection points, either by external id or by name, and
+// sets the static address requested for each of them.
+func (h PluginImpl) getEndpointsConfig(cl *docker.Client, cps []*catalogue.VNFDConnectionPoint, ips map[string]string) ([]*dockerNetwork.EndpointSettings, error) {
+	res := make([]*dockerNetwork.EndpointSettings, 0, len(cps))
+	seen := make(map[string]bool)
+	for _, cp := range cps {
+		netRef := cp.VirtualLinkReferenceID
+		if netRef == "" {
+			netRef = cp.VirtualLinkReference
+		}
+		dockNet, err := cl.NetworkInspect(h.ctx, netRef, types.NetworkInspectOptions{})
+		if err != nil {
+			h.Logger.Errorf("Error inspecting network [%s]: %v", netRef, err)
+			return nil, err
+		}
+		if seen[dockNet.ID] {
+			continue
+		}
+		seen[dockNet.ID] = true
+		endpoint := &dockerNetwork.EndpointSettings{
+			NetworkID: dockNet.ID,
+		}
+		if ip := getRequestedIP(ips, cp.VirtualLinkReference, dockNet.Name, dockNet.ID); ip != "" {
+			ipamConfig, err := getEndpointIPAMConfig(ip, dockNet)
+			if err != nil {
+				h.Logger.Errorf("Not able to use ip %s: %v", ip, err)
+				return nil, err
+			}
+			h.Logger.Debugf("Using static ip %s on network [%s]", ip, dockNet.Name)
+			endpoint.IPAMConfig = ipamConfig
+		}
+		res = append(res, endpoint)
+	}
+	return res, nil
+}
+
+// waitForRunning polls the container state until it is running, failing if the container stops before or the
+// operation is cancelled.
+func (h PluginImpl) waitForRunning(cl *docker.Client, containerId string) error {
+	ticker := time.NewTicker(launchPollInterval)
+	defer ticker.Stop()
+	for {
+		c, err := cl.ContainerInspect(h.ctx, containerId)
+		if err != nil {
+			return err
+		}
+		if c.State.Running {
+			return nil
+		}
+		if c.State.Status == "exited" || c.State.Status == "dead" {
+			return errors.New(fmt.Sprintf("container %s is %s with exit code %d %s", containerId, c.State.Status, c.State.ExitCode, c.State.Error))
+		}
+		select {
+		case <-h.ctx.Done():
+			return errors.New(fmt.Sprintf("container %s not running: %v", containerId, h.ctx.Err()))
+		case <-ticker.C:
+		}
+	}
+}
+
+// getServer builds the catalogue.Server for the container with the given id.
+func (h PluginImpl) getServer(cl *docker.Client, containerId string) (*catalogue.Server, error) {
+	containers, err := cl.ContainerList(h.ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("id", containerId)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(containers) == 0 {
+		return nil, errors.New(fmt.Sprintf("Container with id %s not found", containerId))
+	}
+	img, err := h.getImageById(containers[0].ImageID, cl)
+	if err != nil {
+		return nil, err
+	}
+	dimg, err := getDockerImage(img)
+	if err != nil {
+		return nil, err
+	}
+	return GetContainer(containers[0], dimg)
+}
+
+// copyAuthorizedKeys writes the public keys into the authorized keys file at path, creating its parent directory.
+func (h PluginImpl) copyAuthorizedKeys(cl *docker.Client, containerId string, keys []*catalogue.Key, path string) error {
+	existing, err := h.readContainerFile(cl, containerId, path)
+	if err != nil {
+		return err
+	}
+	archive, err := getAuthorizedKeysArchive(keys, path, existing)
+	if err != nil {
+		return err
+	}
+	h.Logger.Debugf("Copying %d keys to %s in container [%s]", len(keys), path, containerId)
+	return cl.CopyToContainer(h.ctx, containerId, filepath.Dir(filepath.Dir(path)), archive, types.CopyToContainerOptions{})
+}
+
+// readContainerFile reads the file at path and its parent directory from the container, which does not need to run.
+func (h PluginImpl) readContainerFile(cl *docker.Client, containerId, path string) (*containerFile, error) {
+	reader, _, err := cl.CopyFromContainer(h.ctx, containerId, filepath.Dir(path))
+	if err != nil {
+		if docker.IsErrNotFound(err) {
+			return &containerFile{}, nil
+		}
+		h.Logger.Errorf("Error reading %s from container [%s]: %v", filepath.Dir(path), containerId, err)
+		return nil, err
+	}
+	defer reader.Close()
+	return readFileArchive(reader, path)
+}
+
+// removeContainer cleans up after a failed operation. It gets a context of its own, as the one of the operation may be
+// what made it fail.
+func (h PluginImpl) removeContainer(cl *docker.Client, containerId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.getTimeout(OperationDelete))
+	defer cancel()
+	err := cl.ContainerRemove(ctx, containerId, types.ContainerRemoveOptions{
+		Force: true,
+	})
+	if err != nil {
+		h.Logger.Errorf("Error removing container [%s]: %v", containerId, err)
+	}
+}
+func (h PluginImpl) LaunchInstanceAndWaitWithIPs(vimInstance interface{}, hostname, image, extID, keyPair string, network []*catalogue.VNFDConnectionPoint, securityGroups []string, userdata string, floatingIps map[string]string, keys []*catalogue.Key) (*catalogue.Server, error) {
+	return h.launchAndWait(vimInstance, &launchOptions{
+		hostname: hostname,
+		image:    image,
+		flavour:  extID,
+		network:  network,
+		userdata: userdata,
+		ips:      floatingIps,
+		keys:     keys,
+	})
+}
+func (h PluginImpl) ListFlavours(vimInstance interface{}) (_ []*catalogue.DeploymentFlavour, err error) {
+	h, done := h.begin(OperationList)
+	defer done(&err)
+	dockerVimInstance, err := pluginsdk.GetDockerVimInstance(vimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting Docker Vim Instance: %v", err)
+		return nil, err
+	}
+	_, err = h.getClient(dockerVimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting client: %v", err)
+		return nil, err
+	}
+
+	res := h.state.flavours.list()
+	h.Logger.Infof("Listed %d flavours", len(res))
+	return res, nil
+}
+func (h PluginImpl) ListImages(vimInstance interface{}) (_ catalogue.BaseImageInt, err error) {
+	h, done := h.begin(OperationList)
+	defer done(&err)
+	dockerVimInstance, err := pluginsdk.GetDockerVimInstance(vimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting Docker Vim Instance: %v", err)
+		return nil, err
+	}
+	cl, err := h.getClient(dockerVimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting client: %v", err)
+		return nil, err
+	}
+	opt := types.ImageListOptions{}
+	images, err := cl.ImageList(h.ctx, opt)
+	if err != nil {
+		h.Logger.Errorf("Error listing images: %v", err)
+		return nil, err
+	}
+
+	res := make([]*catalogue.DockerImage, len(images))
+
+	for index, img := range images {
+		nfvImg, err := GetImage(img)
+		if err != nil {
+			h.Logger.Errorf("Error translating image: %v", err)
+			return nil, err
+		}
+		res[index] = nfvImg
+	}
+	h.Logger.Infof("Listed %d images", len(res))
+	return res, nil
+}
+func (h PluginImpl) ListNetworks(vimInstance interface{}) (_ catalogue.BaseNetworkInt, err error) {
+	h, done := h.begin(OperationList)
+	defer done(&err)
+	dockerVimInstance, err := pluginsdk.GetDockerVimInstance(vimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting Docker Vim Instance: %v", err)
+		return nil, err
+	}
+	cl, err := h.getClient(dockerVimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting client: %v", err)
+		return nil, err
+	}
+	opt := types.NetworkListOptions{
+		Filters: h.getListFilter(dockerVimInstance),
+	}
+	networksDock, err := cl.NetworkList(h.ctx, opt)
+	if err != nil {
+		h.Logger.Errorf("Error listing networks: %v", err)
+		return nil, err
+	}
+
+	res := make([]*catalogue.DockerNetwork, len(networksDock))
+
+	for index, netDock := range networksDock {
+		obNet, err := GetNetwork(netDock)
+		if err != nil {
+			h.Logger.Errorf("Error translating image: %v", err)
+			return nil, err
+		}
+		res[index] = obNet
+	}
+	h.Logger.Infof("Listed %d networks", len(res))
+	h.Logger.Debugf("Networks are %s", func() []string {
+		v := make([]string, len(res))
+		for i, n := range res {
+			v[i] = n.Name
+		}
+		return v
+	}())
+	return res, nil
+}
+
+func (h PluginImpl) Refresh(vimInstance interface{}) (interface{}, error) {
+	dockerVimInstance, err := pluginsdk.GetDockerVimInstance(vimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting Docker Vim Instance: %v", err)
+		return nil, err
+	}
+	//Images
+	imgs, err := h.ListImages(vimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error listing images: %v", err)
+		return nil, err
+	}
+	imageLen := len(imgs.([]*catalogue.DockerImage))
+	dockerVimInstance.Images = make([]catalogue.DockerImage, imageLen)
+	for i := 0; i < imageLen; i++ {
+		dockerVimInstance.Images[i] = *(imgs.([]*catalogue.DockerImage)[i])
+	}
+	//Networks
+	nets, err := h.ListNetworks(vimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error listing networks: %v", err)
+		return nil, err
+	}
+	netLen := len(nets.([]*catalogue.DockerNetwork))
 	dockerVimInstance.Networks = make([]catalogue.DockerNetwork, netLen)
 	for i := 0; i < netLen; i++ {
 		dockerVimInstance.Networks[i] = *(nets.([]*catalogue.DockerNetwork)[i])
@@ -462,7 +1187,9 @@ func (h PluginImpl) Refresh(vimInstance interface{}) (interface{}, error) {
 	return dockerVimInstance, nil
 }
 
-func (h PluginImpl) ListServer(vimInstance interface{}) ([]*catalogue.Server, error) {
+func (h PluginImpl) ListServer(vimInstance interface{}) (_ []*catalogue.Server, err error) {
+	h, done := h.begin(OperationList)
+	defer done(&err)
 	dockerVimInstance, err := pluginsdk.GetDockerVimInstance(vimInstance)
 	if err != nil {
 		h.Logger.Errorf("Error getting Docker Vim Instance: %v", err)
@@ -473,7 +1200,9 @@ func (h PluginImpl) ListServer(vimInstance interface{}) ([]*catalogue.Server, er
 		h.Logger.Errorf("Error getting client: %v", err)
 		return nil, err
 	}
-	opt := types.ContainerListOptions{}
+	opt := types.ContainerListOptions{
+		Filters: h.getListFilter(dockerVimInstance),
+	}
 	containers, err := cl.ContainerList(h.ctx, opt)
 	if err != nil {
 		h.Logger.Errorf("Error listing networks: %v", err)
@@ -483,24 +1212,30 @@ func (h PluginImpl) ListServer(vimInstance interface{}) ([]*catalogue.Server, er
 	res := make([]*catalogue.Server, 0)
 
 	for _, container := range containers {
-		img, err := h.getImageById(container.Image, cl)
-		dimg, err := getDockerImage(img)
+		// container.Image is the name the container was created with, the image is looked up by its id
 		var server *catalogue.Server
-		if err != nil {
-			h.Logger.Errorf("Error while retrieving the image by id")
-			imageSummary, _, err := cl.ImageInspectWithRaw(h.ctx, dimg.ID)
-			if err != nil {
+		img, err := h.getImageById(container.ImageID, cl)
+		if err == nil {
+			var dimg *catalogue.DockerImage
+			if dimg, err = getDockerImage(img); err == nil {
+				server, err = GetContainer(container, dimg)
+			}
+		} else {
+			h.Logger.Warningf("Error retrieving the image of container [%s] by id, inspecting it: %v", container.ID, err)
+			var imageInspect types.ImageInspect
+			if imageInspect, _, err = cl.ImageInspectWithRaw(h.ctx, container.ImageID); err != nil {
 				h.Logger.Errorf("Error inspecting image: %v", err)
 				return nil, err
 			}
-			server, err = GetContainerWithImgName(container, imageSummary)
-			// return nil, err
+			server, err = GetContainerWithImgName(container, imageInspect)
 		}
-		server, err = GetContainer(container, dimg)
 		if err != nil {
 			h.Logger.Errorf("Error translating image: %v", err)
 			return nil, err
 		}
+		if status, ok := h.state.tracker.status(container.ID); ok {
+			server.Status = status
+		}
 		res = append(res, server)
 	}
 	return res, nil
@@ -527,37 +1262,385 @@ func (h PluginImpl) getImageById(i string, cl *docker.Client) (catalogue.BaseIma
 	return nil, errors.New(fmt.Sprintf("Image with id %s not found", i))
 }
 
-func (h PluginImpl) NetworkByID(vimInstance interface{}, id string) (catalogue.BaseNetworkInt, error) {
-	return nil, nil
+func (h PluginImpl) NetworkByID(vimInstance interface{}, id string) (_ catalogue.BaseNetworkInt, err error) {
+	h, done := h.begin(OperationList)
+	defer done(&err)
+	dockerVimInstance, err := pluginsdk.GetDockerVimInstance(vimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting Docker Vim Instance: %v", err)
+		return nil, err
+	}
+	cl, err := h.getClient(dockerVimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting client: %v", err)
+		return nil, err
+	}
+	dockNet, err := cl.NetworkInspect(h.ctx, id, types.NetworkInspectOptions{})
+	if err != nil {
+		if docker.IsErrNotFound(err) {
+			return nil, &NotFoundError{Kind: "network", ID: id}
+		}
+		h.Logger.Errorf("Error inspecting network: %v", err)
+		return nil, err
+	}
+	obNet, err := GetNetwork(dockNet)
+	if err != nil {
+		h.Logger.Errorf("Error translating network: %v", err)
+		return nil, err
+	}
+	return obNet, nil
 }
-func (h PluginImpl) Quota(vimInstance interface{}) (*catalogue.Quota, error) {
+func (h PluginImpl) Quota(vimInstance interface{}) (_ *catalogue.Quota, err error) {
+	h, done := h.begin(OperationList)
+	defer done(&err)
+	dockerVimInstance, err := pluginsdk.GetDockerVimInstance(vimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting Docker Vim Instance: %v", err)
+		return nil, err
+	}
+	cl, err := h.getClient(dockerVimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting client: %v", err)
+		return nil, err
+	}
+	total, err := h.getTotalCapacity(cl)
+	if err != nil {
+		return nil, err
+	}
+	reserved, instances, err := h.getReservedCapacity(cl)
+	if err != nil {
+		return nil, err
+	}
+	cores, ram := total.available(reserved)
+	// docker does not limit the number of containers, the running ones only take their share of the resources
+	h.Logger.Debugf("Quota is %d cores and %d MB ram, %d containers running", cores, ram, instances)
 	return &catalogue.Quota{
-		RAM:         100000,
-		Cores:       100000,
-		FloatingIPs: 100000,
-		KeyPairs:    100000,
-		Instances:   100000,
+		RAM:         ram,
+		Cores:       cores,
+		FloatingIPs: unlimitedQuota,
+		KeyPairs:    unlimitedQuota,
+		Instances:   unlimitedQuota,
 	}, nil
 }
-func (h PluginImpl) SubnetsExtIDs(vimInstance interface{}, networkExtID string) ([]string, error) {
-	return nil, nil
+func (h PluginImpl) SubnetsExtIDs(vimInstance interface{}, networkExtID string) (_ []string, err error) {
+	h, done := h.begin(OperationList)
+	defer done(&err)
+	dockerVimInstance, err := pluginsdk.GetDockerVimInstance(vimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting Docker Vim Instance: %v", err)
+		return nil, err
+	}
+	cl, err := h.getClient(dockerVimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting client: %v", err)
+		return nil, err
+	}
+	dockNet, err := cl.NetworkInspect(h.ctx, networkExtID, types.NetworkInspectOptions{})
+	if err != nil {
+		if docker.IsErrNotFound(err) {
+			return nil, &NotFoundError{Kind: "network", ID: networkExtID}
+		}
+		h.Logger.Errorf("Error inspecting network: %v", err)
+		return nil, err
+	}
+	res := make([]string, len(dockNet.IPAM.Config))
+	for i, pool := range dockNet.IPAM.Config {
+		res[i] = subnetExtID(dockNet.Name, pool.Subnet)
+	}
+	return res, nil
 }
 func (h PluginImpl) Type(vimInstance interface{}) (string, error) {
 	return "docker", nil
 }
 func (h PluginImpl) UpdateFlavour(vimInstance interface{}, deploymentFlavour *catalogue.DeploymentFlavour) (*catalogue.DeploymentFlavour, error) {
-	return deploymentFlavour, nil
+	if err := h.checkState(); err != nil {
+		return nil, err
+	}
+	flavour, err := h.state.flavours.update(deploymentFlavour)
+	if err != nil {
+		h.Logger.Errorf("Error updating flavour: %v", err)
+		return nil, err
+	}
+	h.Logger.Infof("Updated flavour [%s]", flavour.ExtID)
+	return flavour, nil
 }
 func (h PluginImpl) UpdateImage(vimInstance interface{}, image catalogue.BaseImageInt) (catalogue.BaseImageInt, error) {
 	return image, nil
 }
-func (h PluginImpl) UpdateNetwork(vimInstance interface{}, network catalogue.BaseNetworkInt) (catalogue.BaseNetworkInt, error) {
-	return network, nil
+func (h PluginImpl) UpdateNetwork(vimInstance interface{}, network catalogue.BaseNetworkInt) (_ catalogue.BaseNetworkInt, err error) {
+	h, done := h.begin(OperationCreate)
+	defer done(&err)
+	dockerVimInstance, err := pluginsdk.GetDockerVimInstance(vimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting Docker Vim Instance: %v", err)
+		return nil, err
+	}
+	dockerNet, err := getDockerNet(network)
+	if err != nil {
+		h.Logger.Errorf("Error getting the Docker Network: %v", err)
+		return nil, err
+	}
+	cl, err := h.getClient(dockerVimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting client: %v", err)
+		return nil, err
+	}
+	live, err := h.getManagedNetwork(cl, dockerNet.ExtID)
+	if err != nil {
+		h.Logger.Errorf("Not able to update network [%s]: %v", dockerNet.ExtID, err)
+		return nil, err
+	}
+	desired, err := h.getNetworkCreate(dockerVimInstance, dockerNet)
+	if err != nil {
+		h.Logger.Errorf("Error parsing network: %v", err)
+		return nil, err
+	}
+	if err = h.checkNetworkFeatures(cl, desired); err != nil {
+		h.Logger.Errorf("Not able to update network [%s]: %v", dockerNet.ExtID, err)
+		return nil, err
+	}
+	// without subnets docker chooses the pools, so the current ones are kept
+	if dockerNet.Subnet == "" {
+		desired.IPAM.Config = live.IPAM.Config
+		desired.EnableIPv6 = live.EnableIPv6
+	}
+	if err = h.keepUnsentSettings(live, &desired, dockerNet.Metadata); err != nil {
+		h.Logger.Errorf("Not able to update network [%s]: %v", dockerNet.ExtID, err)
+		return nil, err
+	}
+	if changes := getNetworkChanges(live, desired); len(changes) > 0 {
+		h.Logger.Infof("Recreating network [%s] because of changes to %s", live.Name, strings.Join(changes, ", "))
+		if isHostInterfaceDriver(desired.Driver) {
+			if err = h.validateParent(cl, dockerVimInstance, desired, live.ID); err != nil {
+				h.Logger.Errorf("Not able to update network [%s]: %v", live.Name, err)
+				return nil, err
+			}
+		}
+		recreated, err := h.recreateNetwork(cl, live, desired)
+		if err != nil {
+			h.Logger.Errorf("Error recreating network [%s]: %v", live.Name, err)
+			return nil, err
+		}
+		live = recreated
+	}
+	// the containersKey entry is ignored, it may come from an outdated copy of the network
+	attach, detach := splitList(dockerNet.Metadata[attachKey]), splitList(dockerNet.Metadata[detachKey])
+	if len(attach) > 0 || len(detach) > 0 {
+		if err = h.updateAttachments(cl, live, attach, detach); err != nil {
+			return nil, err
+		}
+		if live, err = cl.NetworkInspect(h.ctx, live.ID, types.NetworkInspectOptions{}); err != nil {
+			h.Logger.Errorf("Error inspecting network: %v", err)
+			return nil, err
+		}
+	}
+	obNet, err := GetNetwork(live)
+	if err != nil {
+		h.Logger.Errorf("Error translating network: %v", err)
+		return nil, err
+	}
+	h.Logger.Infof("Updated network [%s]", obNet.Name)
+	return obNet, nil
 }
 func (h PluginImpl) UpdateSubnet(vimInstance interface{}, createdNetwork catalogue.BaseNetworkInt, subnet *catalogue.Subnet) (*catalogue.Subnet, error) {
 	return subnet, nil
 }
-func (h PluginImpl) RebuildServer(vimInstance interface{}, serverId string, imageId string) (*catalogue.Server, error) {
-	srv := &catalogue.Server{}
+func (h PluginImpl) RebuildServer(vimInstance interface{}, serverId string, imageId string) (_ *catalogue.Server, err error) {
+	h, done := h.begin(OperationCreate)
+	defer done(&err)
+	dockerVimInstance, err := pluginsdk.GetDockerVimInstance(vimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting Docker Vim Instance: %v", err)
+		return nil, err
+	}
+	cl, err := h.getClient(dockerVimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting client: %v", err)
+		return nil, err
+	}
+	old, err := cl.ContainerInspect(h.ctx, serverId)
+	if err != nil {
+		if docker.IsErrNotFound(err) {
+			return nil, &NotFoundError{Kind: "container", ID: serverId}
+		}
+		h.Logger.Errorf("Error inspecting container: %v", err)
+		return nil, err
+	}
+	name := strings.TrimPrefix(old.Name, "/")
+	gracePeriod, err := getStopGracePeriod(dockerVimInstance)
+	if err != nil {
+		h.Logger.Errorf("Error getting the stop grace period: %v", err)
+		return nil, err
+	}
+	config, hostConfig, endpoints, err := h.getRebuildConfig(cl, old, imageId)
+	if err != nil {
+		return nil, err
+	}
+	// the keys and the user-data script were copied into the old container at launch time, not into its image
+	keysPath := getAuthorizedKeysPath(dockerVimInstance)
+	prepare := func(containerId string) error {
+		if err := h.copyOldAuthorizedKeys(cl, old.ID, containerId, keysPath); err != nil {
+			h.Logger.Errorf("Error copying keys into container [%s]: %v", name, err)
+			return err
+		}
+		if getUserdataMode(old.Config.Entrypoint) != "" {
+			if err := h.copyOldUserdataScript(cl, old.ID, containerId); err != nil {
+				h.Logger.Errorf("Error copying user-data into container [%s]: %v", name, err)
+				return err
+			}
+		}
+		return nil
+	}
+
+	// the old container is kept aside until the new one runs, so that it can be restored if anything goes wrong
+	h.Logger.Debugf("Replacing container [%s] with a new one from image [%s]", name, imageId)
+	if old.State.Running {
+		h.Logger.Debugf("Stopping container [%s] with a grace period of %v", name, gracePeriod)
+		if err = cl.ContainerStop(h.ctx, old.ID, &gracePeriod); err != nil {
+			h.Logger.Errorf("Error stopping container: %v", err)
+			return nil, err
+		}
+	}
+	if err = cl.ContainerRename(h.ctx, old.ID, name+"_rebuild"); err != nil {
+		h.Logger.Errorf("Error renaming container: %v", err)
+		return nil, err
+	}
+	for _, endpoint := range endpoints {
+		if err = cl.NetworkDisconnect(h.ctx, endpoint.NetworkID, old.ID, true); err != nil {
+			h.Logger.Warningf("Error disconnecting container [%s] from network [%s]: %v", name, endpoint.NetworkID, err)
+		}
+	}
+	containerId, err := h.runContainer(cl, name, config, hostConfig, endpoints, prepare)
+	if err == nil {
+		err = h.waitForRunning(cl, containerId)
+		if err != nil {
+			h.removeContainer(cl, containerId)
+		}
+	}
+	if err != nil {
+		h.Logger.Errorf("Error rebuilding container [%s], restoring the old one: %v", name, err)
+		h.restoreContainer(cl, old, name, endpoints)
+		return nil, err
+	}
+	err = cl.ContainerRemove(h.ctx, old.ID, types.ContainerRemoveOptions{})
+	if err != nil {
+		h.Logger.Warningf("Error removing the old container [%s]: %v", old.ID, err)
+	}
+	h.state.tracker.forget(old.ID)
+	srv, err := h.getServer(cl, containerId)
+	if err != nil {
+		h.Logger.Errorf("Error translating container: %v", err)
+		return nil, err
+	}
+	h.Logger.Infof("Rebuilt container [%s] from image [%s] with new id [%s]", name, imageId, srv.ExtID)
 	return srv, nil
 }
+
+// getRebuildConfig returns the configuration to recreate the container from the image with the same hostname,
+// networks, static ips, environment, mounts, labels and user-data entrypoint. Environment and labels inherited from the
+// old image are left out so that those of the new image apply.
+func (h PluginImpl) getRebuildConfig(cl *docker.Client, old types.ContainerJSON, imageId string) (*container.Config, *container.HostConfig, []*dockerNetwork.EndpointSettings, error) {
+	config := &container.Config{
+		Image:    imageId,
+		Hostname: old.Config.Hostname,
+		Labels:   make(map[string]string),
+	}
+	oldImage, _, err := cl.ImageInspectWithRaw(h.ctx, old.Image)
+	if err != nil {
+		h.Logger.Errorf("Error inspecting image %s: %v", old.Image, err)
+		return nil, nil, nil, err
+	}
+	oldImageConfig := oldImage.Config
+	if oldImageConfig == nil {
+		oldImageConfig = &container.Config{}
+	}
+	// the user-data entrypoint wraps the one of the image, so it is built again around the one of the new image
+	if mode := getUserdataMode(old.Config.Entrypoint); mode != "" {
+		if err = h.applyUserdata(cl, config, &userdataConfig{mode: mode}); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	for _, env := range old.Config.Env {
+		if !stringEqualInSlice(env, oldImageConfig.Env) {
+			config.Env = append(config.Env, env)
+		}
+	}
+	for key, val := range old.Config.Labels {
+		if imageVal, ok := oldImageConfig.Labels[key]; !ok || imageVal != val {
+			config.Labels[key] = val
+		}
+	}
+	hostConfig := old.HostConfig
+	if hostConfig == nil {
+		hostConfig = &container.HostConfig{}
+	}
+	// anonymous volumes are mounted explicitly otherwise they would be lost with the old container
+	for _, m := range old.Mounts {
+		if m.Type == mount.TypeVolume && !isMountTarget(hostConfig, m.Destination) {
+			hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{
+				Type:     mount.TypeVolume,
+				Source:   m.Name,
+				Target:   m.Destination,
+				ReadOnly: !m.RW,
+			})
+		}
+	}
+	endpoints := make([]*dockerNetwork.EndpointSettings, 0, len(old.NetworkSettings.Networks))
+	for _, endpoint := range old.NetworkSettings.Networks {
+		endpoints = append(endpoints, &dockerNetwork.EndpointSettings{
+			NetworkID:  endpoint.NetworkID,
+			IPAMConfig: endpoint.IPAMConfig,
+			Aliases:    endpoint.Aliases,
+		})
+	}
+	return config, hostConfig, endpoints, nil
+}
+
+// copyOldAuthorizedKeys adds the keys of the old container to the new one, if there are any.
+func (h PluginImpl) copyOldAuthorizedKeys(cl *docker.Client, oldId, containerId, path string) error {
+	existing, err := h.readContainerFile(cl, oldId, path)
+	if err != nil {
+		return err
+	}
+	if existing.file == nil {
+		return nil
+	}
+	var keys []*catalogue.Key
+	for _, line := range strings.Split(string(existing.content), "\n") {
+		keys = append(keys, &catalogue.Key{PublicKey: line})
+	}
+	return h.copyAuthorizedKeys(cl, containerId, keys, path)
+}
+
+// copyOldUserdataScript copies the user-data script the entrypoint of the old container runs into the new one.
+func (h PluginImpl) copyOldUserdataScript(cl *docker.Client, oldId, containerId string) error {
+	existing, err := h.readContainerFile(cl, oldId, userdataScriptPath)
+	if err != nil {
+		return err
+	}
+	if existing.file == nil {
+		return errors.New(fmt.Sprintf("user-data script %s not found in container %s", userdataScriptPath, oldId))
+	}
+	archive, err := getFileArchive(userdataScriptPath, 0755, existing.content, nil)
+	if err != nil {
+		return err
+	}
+	return cl.CopyToContainer(h.ctx, containerId, filepath.Dir(filepath.Dir(userdataScriptPath)), archive, types.CopyToContainerOptions{})
+}
+
+// restoreContainer brings back the container put aside by RebuildServer.
+func (h PluginImpl) restoreContainer(cl *docker.Client, old types.ContainerJSON, name string, endpoints []*dockerNetwork.EndpointSettings) {
+	if err := cl.ContainerRename(h.ctx, old.ID, name); err != nil {
+		h.Logger.Errorf("Error renaming container [%s] back: %v", old.ID, err)
+	}
+	for _, endpoint := range endpoints {
+		if err := cl.NetworkConnect(h.ctx, endpoint.NetworkID, old.ID, endpoint); err != nil {
+			h.Logger.Errorf("Error reconnecting container [%s] to network [%s]: %v", old.ID, endpoint.NetworkID, err)
+		}
+	}
+	if old.State.Running {
+		if err := cl.ContainerStart(h.ctx, old.ID, types.ContainerStartOptions{}); err != nil {
+			h.Logger.Errorf("Error restarting container [%s]: %v", old.ID, err)
+		}
+	}
+}
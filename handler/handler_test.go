@@ -1,7 +1,27 @@
 package handler
 
 import (
+	"archive/tar"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"io"
+	"net"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 	"github.com/op/go-logging"
 	"github.com/openbaton/go-openbaton/sdk"
 	"github.com/openbaton/go-openbaton/catalogue"
@@ -10,7 +30,9 @@ import (
 	"fmt"
 	"docker.io/go-docker/api/types"
 	client "docker.io/go-docker"
+	"docker.io/go-docker/api/types/container"
 	"docker.io/go-docker/api/types/filters"
+	dockerNetwork "docker.io/go-docker/api/types/network"
 )
 
 var log *logging.Logger = sdk.GetLogger("docker_test", "DEBUG")
@@ -102,4 +124,1538 @@ func getVimInstance() *catalogue.DockerVimInstance {
 			Type:    "docker",
 		},
 	}
-}
\ No newline at end of file
+}
+func TestGetEndpointIPAMConfig(t *testing.T) {
+	netRes := types.NetworkResource{
+		Name: "private",
+		IPAM: dockerNetwork.IPAM{
+			Config: []dockerNetwork.IPAMConfig{{Subnet: "10.10.0.0/24"}},
+		},
+	}
+	cfg, err := getEndpointIPAMConfig("10.10.0.5", netRes)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.10.0.5", cfg.IPv4Address)
+
+	_, err = getEndpointIPAMConfig("10.20.0.5", netRes)
+	assert.NotNil(t, err)
+	_, err = getEndpointIPAMConfig("not-an-ip", netRes)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, "10.10.0.5", getRequestedIP(map[string]string{"private": "10.10.0.5"}, "", "private"))
+	assert.Equal(t, "", getRequestedIP(map[string]string{"private": "random"}, "private"))
+}
+
+func TestGetAuthorizedKeysArchive(t *testing.T) {
+	keys := []*catalogue.Key{{Name: "ops", PublicKey: "ssh-rsa AAAA ops@host\n"}, {Name: "empty"}}
+	buf, err := getAuthorizedKeysArchive(keys, "/root/.ssh/authorized_keys", nil)
+	assert.Nil(t, err)
+	tr := tar.NewReader(buf)
+	hdr, err := tr.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, ".ssh/", hdr.Name)
+	hdr, err = tr.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, ".ssh/authorized_keys", hdr.Name)
+	content, err := ioutil.ReadAll(tr)
+	assert.Nil(t, err)
+	assert.Equal(t, "ssh-rsa AAAA ops@host\n", string(content))
+
+	_, err = getAuthorizedKeysArchive(keys, "/authorized_keys", nil)
+	assert.NotNil(t, err)
+
+	// the existing file and its directory are kept, the keys are merged
+	var existing bytes.Buffer
+	tw := tar.NewWriter(&existing)
+	old := "ssh-ed25519 BBBB user@image\nssh-rsa AAAA ops@host\n"
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: ".ssh/", Mode: 0700, Uid: 1000, Gid: 1000, Typeflag: tar.TypeDir}))
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: ".ssh/authorized_keys", Mode: 0644, Uid: 1000, Gid: 1000,
+		Size: int64(len(old)), Typeflag: tar.TypeReg}))
+	_, err = tw.Write([]byte(old))
+	assert.Nil(t, err)
+	assert.Nil(t, tw.Close())
+	file, err := readFileArchive(&existing, "/home/user/.ssh/authorized_keys")
+	assert.Nil(t, err)
+	assert.NotNil(t, file.dir)
+	assert.NotNil(t, file.file)
+
+	keys = append(keys, &catalogue.Key{Name: "new", PublicKey: "ssh-rsa CCCC new@host"})
+	buf, err = getAuthorizedKeysArchive(keys, "/home/user/.ssh/authorized_keys", file)
+	assert.Nil(t, err)
+	tr = tar.NewReader(buf)
+	hdr, err = tr.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, ".ssh/authorized_keys", hdr.Name)
+	assert.Equal(t, int64(0644), hdr.Mode)
+	assert.Equal(t, 1000, hdr.Uid)
+	content, err = ioutil.ReadAll(tr)
+	assert.Nil(t, err)
+	assert.Equal(t, old+"ssh-rsa CCCC new@host\n", string(content))
+	_, err = tr.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// only the directory exists: the new file belongs to its owner
+	buf, err = getAuthorizedKeysArchive(keys, "/home/user/.ssh/authorized_keys", &containerFile{dir: file.dir})
+	assert.Nil(t, err)
+	hdr, err = tar.NewReader(buf).Next()
+	assert.Nil(t, err)
+	assert.Equal(t, ".ssh/authorized_keys", hdr.Name)
+	assert.Equal(t, int64(0600), hdr.Mode)
+	assert.Equal(t, 1000, hdr.Gid)
+}
+
+func TestParseUserdata(t *testing.T) {
+	u, err := parseUserdata("#!/bin/bash\necho hello")
+	assert.Nil(t, err)
+	assert.Nil(t, u)
+
+	u, err = parseUserdata("#docker-userdata: env\n# comment\nFOO=bar\nexport BAZ=a=b\n\n")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"FOO=bar", "BAZ=a=b"}, u.env)
+
+	_, err = parseUserdata("#docker-userdata: env\nnot a variable")
+	assert.NotNil(t, err)
+
+	u, err = parseUserdata("#docker-userdata: init\n#!/bin/sh\necho hello")
+	assert.Nil(t, err)
+	assert.Equal(t, "#!/bin/sh\necho hello", string(u.script))
+	assert.Equal(t, []string{"/bin/sh", "-c", userdataScriptPath + ` && exec "$@"`, "userdata", "nginx", "-g"},
+		[]string(u.getEntrypoint([]string{"nginx"}, []string{"-g"})))
+
+	assert.Equal(t, UserdataInit, getUserdataMode(u.getEntrypoint([]string{"nginx"}, []string{"-g"})))
+	assert.Equal(t, UserdataInit, getUserdataMode(u.getEntrypoint(nil, nil)))
+	assert.Equal(t, UserdataEntrypoint, getUserdataMode([]string{userdataScriptPath}))
+	assert.Equal(t, "", getUserdataMode([]string{"nginx", "-g"}))
+	assert.Equal(t, "", getUserdataMode(nil))
+
+	_, err = parseUserdata("#docker-userdata: entrypoint\necho hello")
+	assert.NotNil(t, err)
+	_, err = parseUserdata("#docker-userdata: unknown\n")
+	assert.NotNil(t, err)
+}
+
+func TestStopGracePeriod(t *testing.T) {
+	instance := getVimInstance()
+	gracePeriod, err := getStopGracePeriod(instance)
+	assert.Nil(t, err)
+	assert.Equal(t, defaultStopGracePeriod, gracePeriod)
+
+	instance.Metadata = map[string]string{stopGracePeriodKey: "3"}
+	gracePeriod, err = getStopGracePeriod(instance)
+	assert.Nil(t, err)
+	assert.Equal(t, 3*time.Second, gracePeriod)
+
+	instance.Metadata[stopGracePeriodKey] = "3s"
+	_, err = getStopGracePeriod(instance)
+	assert.NotNil(t, err)
+}
+
+const testImage = "alpine:3.8"
+
+// launchTestContainer needs a docker daemon on the default socket and is skipped without it. The container runs a
+// user-data entrypoint and gets a public key.
+func launchTestContainer(t *testing.T, hand *PluginImpl, instance *catalogue.DockerVimInstance, name string) (*client.Client, *catalogue.Server) {
+	if !exists("/var/run/docker.sock") {
+		t.Skip("docker daemon not available")
+	}
+	cli, background := NewClientAndBackground()
+	reader, err := cli.ImagePull(background, testImage, types.ImagePullOptions{})
+	if err != nil {
+		t.Skipf("image %s not available: %v", testImage, err)
+	}
+	io.Copy(ioutil.Discard, reader)
+	reader.Close()
+	hand.Logger = log
+	keys := []*catalogue.Key{{Name: "ops", PublicKey: "ssh-rsa AAAA ops@host"}}
+	srv, err := hand.LaunchInstanceAndWaitWithIPs(instance, name, testImage, "", "", nil, nil,
+		"#docker-userdata: entrypoint\n#!/bin/sh\nexec sleep 600", nil, keys)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return cli, srv
+}
+
+func readTestContainerFile(t *testing.T, cli *client.Client, containerId, path string) *containerFile {
+	reader, _, err := cli.CopyFromContainer(context.Background(), containerId, filepath.Dir(path))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer reader.Close()
+	file, err := readFileArchive(reader, path)
+	assert.Nil(t, err)
+	return file
+}
+
+func TestRebuildServer(t *testing.T) {
+	hand := NewHandlerPlugin(false)
+	instance := getVimInstance()
+	instance.Metadata = map[string]string{stopGracePeriodKey: "1"}
+	cli, srv := launchTestContainer(t, hand, instance, "test-rebuild")
+	defer hand.DeleteServerByIDAndWait(instance, srv.ExtID)
+
+	rebuilt, err := hand.RebuildServer(instance, srv.ExtID, testImage)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer hand.DeleteServerByIDAndWait(instance, rebuilt.ExtID)
+	assert.NotEqual(t, srv.ExtID, rebuilt.ExtID)
+	c, err := cli.ContainerInspect(context.Background(), rebuilt.ExtID)
+	assert.Nil(t, err)
+	assert.True(t, c.State.Running)
+	assert.Equal(t, "/test-rebuild", c.Name)
+	assert.Equal(t, UserdataEntrypoint, getUserdataMode(c.Config.Entrypoint))
+	keys := readTestContainerFile(t, cli, rebuilt.ExtID, defaultAuthorizedKeysPath)
+	assert.Equal(t, "ssh-rsa AAAA ops@host\n", string(keys.content))
+	script := readTestContainerFile(t, cli, rebuilt.ExtID, userdataScriptPath)
+	assert.Equal(t, "#!/bin/sh\nexec sleep 600", string(script.content))
+	_, err = cli.ContainerInspect(context.Background(), srv.ExtID)
+	assert.True(t, client.IsErrNotFound(err))
+}
+
+func TestRebuildServerRestore(t *testing.T) {
+	hand := NewHandlerPlugin(false)
+	instance := getVimInstance()
+	instance.Metadata = map[string]string{stopGracePeriodKey: "1"}
+	cli, srv := launchTestContainer(t, hand, instance, "test-rebuild-restore")
+	defer hand.DeleteServerByIDAndWait(instance, srv.ExtID)
+
+	_, err := hand.RebuildServer(instance, srv.ExtID, "openbaton/does-not-exist:latest")
+	assert.NotNil(t, err)
+	c, err := cli.ContainerInspect(context.Background(), srv.ExtID)
+	assert.Nil(t, err)
+	assert.True(t, c.State.Running)
+	assert.Equal(t, "/test-rebuild-restore", c.Name)
+	assert.Len(t, c.NetworkSettings.Networks, 1)
+}
+
+func TestDeleteServer(t *testing.T) {
+	hand := NewHandlerPlugin(false)
+	instance := getVimInstance()
+	instance.Metadata = map[string]string{stopGracePeriodKey: "1"}
+	cli, srv := launchTestContainer(t, hand, instance, "test-delete")
+
+	assert.Nil(t, hand.DeleteServerByIDAndWait(instance, srv.ExtID))
+	_, err := cli.ContainerInspect(context.Background(), srv.ExtID)
+	assert.True(t, client.IsErrNotFound(err))
+	err = hand.DeleteServerByIDAndWait(instance, srv.ExtID)
+	assert.True(t, IsNotFound(err))
+
+	instance.Metadata[stopGracePeriodKey] = "soon"
+	_, srv = launchTestContainer(t, hand, instance, "test-delete")
+	assert.NotNil(t, hand.DeleteServerByIDAndWait(instance, srv.ExtID))
+	instance.Metadata[stopGracePeriodKey] = "1"
+	assert.Nil(t, hand.DeleteServerByIDAndWait(instance, srv.ExtID))
+}
+
+func TestFlavourRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flavours")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "flavours.json")
+
+	reg := newFlavourRegistry()
+	assert.Nil(t, reg.load(path))
+	assert.Len(t, reg.list(), 1)
+
+	f, err := reg.add(&catalogue.DeploymentFlavour{FlavourKey: "m1.medium", VCPUs: 2, RAM: 4096, Disk: 10})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, f.ExtID)
+	_, err = reg.add(&catalogue.DeploymentFlavour{FlavourKey: "m1.medium"})
+	assert.NotNil(t, err)
+
+	reloaded := newFlavourRegistry()
+	assert.Nil(t, reloaded.load(path))
+	byKey, ok := reloaded.get("m1.medium")
+	assert.True(t, ok)
+	assert.Equal(t, f.ExtID, byKey.ExtID)
+
+	hostConfig := &container.HostConfig{}
+	applyFlavour(byKey, hostConfig)
+	assert.Equal(t, int64(2e9), hostConfig.NanoCPUs)
+	assert.Equal(t, int64(4096*1024*1024), hostConfig.Memory)
+	assert.Equal(t, "10G", hostConfig.StorageOpt["size"])
+
+	assert.Nil(t, reloaded.delete(f.ExtID))
+	assert.True(t, IsNotFound(reloaded.delete(f.ExtID)))
+
+	// the default flavour cannot be deleted and comes back if the file does not have it
+	assert.NotNil(t, reloaded.delete(defaultFlavour.ExtID))
+	content, err := json.Marshal([]*catalogue.DeploymentFlavour{{ExtID: "1", FlavourKey: "m1.large"}})
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(path, content, 0644))
+	reloaded = newFlavourRegistry()
+	assert.Nil(t, reloaded.load(path))
+	_, ok = reloaded.get(defaultFlavour.FlavourKey)
+	assert.True(t, ok)
+	assert.Len(t, reloaded.list(), 2)
+}
+
+func TestHostCapacityAvailable(t *testing.T) {
+	total := &hostCapacity{nanoCPUs: 4e9, memory: 8 * 1024 * 1024 * 1024}
+	cores, ram := total.available(&hostCapacity{nanoCPUs: 1.5e9, memory: 1024 * 1024 * 1024})
+	assert.Equal(t, 2, cores)
+	assert.Equal(t, 7*1024, ram)
+	cores, ram = total.available(&hostCapacity{nanoCPUs: 8e9, memory: 16 * 1024 * 1024 * 1024})
+	assert.Equal(t, 0, cores)
+	assert.Equal(t, 0, ram)
+}
+
+func TestGetNetwork(t *testing.T) {
+	obNet, err := GetNetwork(types.NetworkResource{
+		ID:     "abcdef123456",
+		Name:   "private",
+		Driver: "bridge",
+		IPAM: dockerNetwork.IPAM{
+			Driver: "default",
+			Config: []dockerNetwork.IPAMConfig{{Subnet: "10.10.0.0/24", Gateway: "10.10.0.1"}},
+		},
+		Containers: map[string]types.EndpointResource{
+			"c2": {Name: "second"},
+			"c1": {Name: "first"},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "10.10.0.0/24", obNet.Subnet)
+	assert.Equal(t, "10.10.0.1", obNet.Gateway)
+	assert.Equal(t, "default", obNet.Metadata["ipam-driver"])
+	assert.Equal(t, "c1,c2", obNet.Metadata["containers"])
+}
+
+func TestGetIPAMConfigs(t *testing.T) {
+	pools, err := getIPAMConfigs("10.10.0.0/24, 10.20.0.0/24")
+	assert.Nil(t, err)
+	assert.Len(t, pools, 2)
+	assert.Equal(t, "10.20.0.1", pools[1].Gateway)
+
+	_, err = getIPAMConfigs("10.10.0.0/16,10.10.1.0/24")
+	assert.NotNil(t, err)
+	_, err = getIPAMConfigs("10.10.0.0")
+	assert.NotNil(t, err)
+
+	pools, err = getIPAMConfigs("10.10.0.5/24,fd00:10::/64")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.10.0.1", pools[0].Gateway)
+	assert.Equal(t, "fd00:10::1", pools[1].Gateway)
+	assert.True(t, hasIPv6Pool(pools))
+	assert.False(t, hasIPv6Pool(pools[:1]))
+	_, err = getIPAMConfigs("fd00:10::/127")
+	assert.NotNil(t, err)
+
+	obNet, err := GetNetwork(types.NetworkResource{IPAM: dockerNetwork.IPAM{Config: pools}, EnableIPv6: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "10.10.0.0/24,fd00:10::/64", obNet.Subnet)
+	assert.Equal(t, "10.10.0.1,fd00:10::1", obNet.Gateway)
+
+	assert.Equal(t, subnetExtID("private", "10.10.0.0/24"), subnetExtID("private", "10.10.0.0/24"))
+	assert.NotEqual(t, subnetExtID("private", "10.10.0.0/24"), subnetExtID("public", "10.10.0.0/24"))
+}
+
+func TestGetNetworkChanges(t *testing.T) {
+	hand := NewHandlerPlugin(false)
+	dockerNet := &catalogue.DockerNetwork{Subnet: "10.10.0.0/24"}
+	dockerNet.Metadata = map[string]string{"label.tier": "data", "opt.com.docker.network.driver.mtu": "1400"}
+	desired, err := hand.getNetworkCreate(getVimInstance(), dockerNet)
+	assert.Nil(t, err)
+	live := types.NetworkResource{
+		Driver:  "bridge",
+		IPAM:    dockerNetwork.IPAM{Config: []dockerNetwork.IPAMConfig{{Subnet: "10.10.0.0/24"}}},
+		Labels:  map[string]string{managedLabel: "docker", vimLabel: "TestDocker", "tier": "data"},
+		Options: map[string]string{"com.docker.network.driver.mtu": "1400"},
+	}
+	assert.Empty(t, getNetworkChanges(live, desired))
+
+	live.Labels["tier"] = "control"
+	live.IPAM.Config[0].Subnet = "10.20.0.0/24"
+	assert.Equal(t, []string{"subnets", "labels"}, getNetworkChanges(live, desired))
+}
+
+func TestGetNetworkRoundTrip(t *testing.T) {
+	hand := NewHandlerPlugin(false)
+	live := types.NetworkResource{
+		ID:         "abcdef123456",
+		Name:       "private_0123abcd",
+		Driver:     "macvlan",
+		Internal:   true,
+		Attachable: true,
+		IPAM: dockerNetwork.IPAM{
+			Driver:  "default",
+			Options: map[string]string{"foo": "bar"},
+			Config: []dockerNetwork.IPAMConfig{{
+				Subnet:     "10.10.0.0/24",
+				Gateway:    "10.10.0.1",
+				IPRange:    "10.10.0.128/25",
+				AuxAddress: map[string]string{"router": "10.10.0.2"},
+			}},
+		},
+		Labels: map[string]string{
+			managedLabel: "docker",
+			vimLabel:     "TestDocker",
+			nsrIdLabel:   "nsr",
+			vnfrIdLabel:  "vnfr",
+			"tier":       "data",
+		},
+		Options: map[string]string{
+			"parent": "eth1.100",
+			"com.docker.network.driver.overlay.vxlanid_list": "4097",
+		},
+	}
+	obNet, err := GetNetwork(live)
+	assert.Nil(t, err)
+	assert.Equal(t, "nsr", obNet.Metadata[nsrIdKey])
+	assert.Equal(t, "eth1.100", obNet.Metadata["opt.parent"])
+	desired, err := hand.getNetworkCreate(getVimInstance(), obNet)
+	assert.Nil(t, err)
+	assert.Empty(t, getNetworkChanges(live, desired))
+	assert.Equal(t, "10.10.0.2", desired.IPAM.Config[0].AuxAddress["router"])
+
+	// an update sending only some entries keeps the others
+	sparse := &catalogue.DockerNetwork{Subnet: "10.10.0.0/24"}
+	sparse.Metadata = map[string]string{"containers": "c1"}
+	desired, err = hand.getNetworkCreate(getVimInstance(), sparse)
+	assert.Nil(t, err)
+	assert.Nil(t, hand.keepUnsentSettings(live, &desired, sparse.Metadata))
+	assert.Empty(t, getNetworkChanges(live, desired))
+
+	sparse.Metadata["label.tier"] = "control"
+	desired, err = hand.getNetworkCreate(getVimInstance(), sparse)
+	assert.Nil(t, err)
+	assert.Nil(t, hand.keepUnsentSettings(live, &desired, sparse.Metadata))
+	assert.Equal(t, []string{"labels"}, getNetworkChanges(live, desired))
+	assert.Equal(t, "eth1.100", desired.Options["parent"])
+
+	// entries are removed by listing them in unset
+	sparse.Metadata = map[string]string{unsetKey: "label.tier, opt.parent,aux-address.router"}
+	desired, err = hand.getNetworkCreate(getVimInstance(), sparse)
+	assert.Nil(t, err)
+	assert.Nil(t, hand.keepUnsentSettings(live, &desired, sparse.Metadata))
+	assert.Equal(t, []string{"labels", "options"}, getNetworkChanges(live, desired))
+	assert.NotContains(t, desired.Labels, "tier")
+	assert.NotContains(t, desired.Options, "parent")
+	assert.Empty(t, desired.IPAM.Config[0].AuxAddress)
+
+	sparse.Metadata = map[string]string{unsetKey: "label.tier", "label.tier": "control"}
+	assert.NotNil(t, hand.keepUnsentSettings(live, &desired, sparse.Metadata))
+	sparse.Metadata = map[string]string{unsetKey: "internal"}
+	assert.NotNil(t, hand.keepUnsentSettings(live, &desired, sparse.Metadata))
+
+	// the labels of the driver can be neither set nor unset
+	sparse.Metadata = map[string]string{unsetKey: labelPrefix + vimLabel}
+	assert.NotNil(t, hand.keepUnsentSettings(live, &desired, sparse.Metadata))
+	sparse.Metadata = map[string]string{labelPrefix + managedLabel: "other"}
+	_, err = hand.getNetworkCreate(getVimInstance(), sparse)
+	assert.NotNil(t, err)
+	sparse.Metadata = map[string]string{labelPrefix + "org.openbaton.custom": "value"}
+	_, err = hand.getNetworkCreate(getVimInstance(), sparse)
+	assert.NotNil(t, err)
+}
+
+func TestUpdateNetworkAttachments(t *testing.T) {
+	server := fakeDockerHost()
+	defer server.Close()
+	instance := server.instance("attachments")
+	hand := NewHandlerPlugin(false)
+	hand.Logger = log
+	hand.DriverID = "docker"
+
+	netId := server.addNetwork("private", hand.getOwnerLabels(instance, nil))
+	first, err := hand.LaunchInstanceAndWait(instance, "first", "image:latest", "", "", nil, nil, "")
+	assert.Nil(t, err)
+	second, err := hand.LaunchInstanceAndWait(instance, "second", "image:latest", "", "", nil, nil, "")
+	assert.Nil(t, err)
+
+	obNet, err := hand.NetworkByID(instance, netId)
+	assert.Nil(t, err)
+	stale := obNet.(*catalogue.DockerNetwork)
+	assert.NotContains(t, stale.Metadata, containersKey)
+
+	update := &catalogue.DockerNetwork{}
+	update.ExtID = netId
+	update.Metadata = map[string]string{attachKey: first.ExtID + ",second"}
+	updated, err := hand.UpdateNetwork(instance, update)
+	assert.Nil(t, err)
+	assert.Equal(t, first.ExtID+","+second.ExtID, updated.(*catalogue.DockerNetwork).Metadata[containersKey])
+
+	// the stale copy without containers does not detach anything
+	updated, err = hand.UpdateNetwork(instance, stale)
+	assert.Nil(t, err)
+	assert.Equal(t, first.ExtID+","+second.ExtID, updated.(*catalogue.DockerNetwork).Metadata[containersKey])
+	update.Metadata = map[string]string{detachKey: "first", attachKey: "second"}
+	updated, err = hand.UpdateNetwork(instance, update)
+	assert.Nil(t, err)
+	assert.Equal(t, second.ExtID, updated.(*catalogue.DockerNetwork).Metadata[containersKey])
+}
+
+func TestDeleteNetwork(t *testing.T) {
+	server := fakeDockerHost()
+	defer server.Close()
+	instance := server.instance("delete")
+	hand := NewHandlerPlugin(false)
+	hand.Logger = log
+	hand.DriverID = "docker"
+
+	foreignId := server.addNetwork("foreign", nil)
+	_, err := hand.DeleteNetwork(instance, foreignId)
+	_, ok := err.(*NotManagedError)
+	assert.True(t, ok)
+	_, ok = server.network(foreignId)
+	assert.True(t, ok)
+
+	netId := server.addNetwork("private", hand.getOwnerLabels(instance, nil))
+	first, err := hand.LaunchInstanceAndWait(instance, "first", "image:latest", "", "", nil, nil, "")
+	assert.Nil(t, err)
+	update := &catalogue.DockerNetwork{}
+	update.ExtID = netId
+	update.Metadata = map[string]string{attachKey: first.ExtID}
+	_, err = hand.UpdateNetwork(instance, update)
+	assert.Nil(t, err)
+
+	// the containers are listed in the error, by default nothing is detached
+	_, err = hand.DeleteNetwork(instance, netId)
+	inUse, ok := err.(*NetworkInUseError)
+	assert.True(t, ok)
+	assert.Equal(t, "private", inUse.Network)
+	assert.Equal(t, []string{"first (" + first.ExtID + ")"}, inUse.Containers)
+	n, ok := server.network(netId)
+	assert.True(t, ok)
+	assert.Len(t, n.Containers, 1)
+
+	instance.Metadata = map[string]string{networkDeletePolicyKey: DeletePolicyDetach}
+	deleted, err := hand.DeleteNetwork(instance, netId)
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+	_, ok = server.network(netId)
+	assert.False(t, ok)
+	server.mu.Lock()
+	_, ok = server.container(first.ExtID)
+	server.mu.Unlock()
+	assert.True(t, ok)
+
+	instance.Metadata[networkDeletePolicyKey] = "force"
+	_, err = hand.DeleteNetwork(instance, foreignId)
+	assert.NotNil(t, err)
+}
+
+func TestCreateSubnet(t *testing.T) {
+	server := fakeDockerHost()
+	defer server.Close()
+	instance := server.instance("subnets")
+	hand := NewHandlerPlugin(false)
+	hand.Logger = log
+	hand.DriverID = "docker"
+
+	network := &catalogue.DockerNetwork{BaseNetwork: catalogue.BaseNetwork{Name: "private"}, Subnet: "10.10.0.5/24"}
+	created, err := hand.CreateNetwork(instance, network)
+	assert.Nil(t, err)
+	netId := created.(*catalogue.DockerNetwork).ExtID
+
+	// the subnet of the network was created with it, written in any way
+	subnet, err := hand.CreateSubnet(instance, created, &catalogue.Subnet{CIDR: "10.10.0.9/24"})
+	assert.Nil(t, err)
+	assert.Equal(t, "10.10.0.0/24", subnet.CIDR)
+	assert.Equal(t, "10.10.0.1", subnet.GatewayIP)
+	assert.Equal(t, netId, subnet.NetworkID)
+
+	added, err := hand.CreateSubnet(instance, created, &catalogue.Subnet{CIDR: "10.20.0.0/24"})
+	assert.Nil(t, err)
+	assert.NotEqual(t, netId, added.NetworkID)
+	// adding a pool recreates the network with another id
+	created.(*catalogue.DockerNetwork).ExtID = added.NetworkID
+	again, err := hand.CreateSubnet(instance, created, &catalogue.Subnet{CIDR: "10.20.0.3/24"})
+	assert.Nil(t, err)
+	assert.Equal(t, added.ExtID, again.ExtID)
+	assert.Equal(t, added.NetworkID, again.NetworkID)
+	n, ok := server.network(added.NetworkID)
+	assert.True(t, ok)
+	assert.Len(t, n.IPAM.Config, 2)
+
+	_, err = hand.CreateSubnet(instance, created, &catalogue.Subnet{CIDR: "10.20.0.128/25"})
+	assert.NotNil(t, err)
+}
+
+func TestGetNetworkName(t *testing.T) {
+	first := &catalogue.DockerNetwork{BaseNetwork: catalogue.BaseNetwork{Name: "my_private"}}
+	first.Metadata = map[string]string{nsrIdKey: "nsr-1"}
+	second := &catalogue.DockerNetwork{BaseNetwork: catalogue.BaseNetwork{Name: "my_private"}}
+	second.Metadata = map[string]string{nsrIdKey: "nsr-2"}
+
+	name, err := getNetworkName(first)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(name, "my_private_"))
+	assert.Len(t, name, len("my_private_")+8)
+	again, _ := getNetworkName(first)
+	assert.Equal(t, name, again)
+	other, _ := getNetworkName(second)
+	assert.NotEqual(t, name, other)
+
+	// without records every request gets its own network
+	third := &catalogue.DockerNetwork{BaseNetwork: catalogue.BaseNetwork{Name: "my_private"}}
+	unique, err := getNetworkName(third)
+	assert.Nil(t, err)
+	assert.Len(t, unique, len("my_private_")+8)
+	again, _ = getNetworkName(third)
+	assert.NotEqual(t, unique, again)
+}
+
+func TestGetExistingNetwork(t *testing.T) {
+	hand := NewHandlerPlugin(false)
+	dockerNet := &catalogue.DockerNetwork{Subnet: "10.10.0.0/24"}
+	dockerNet.Metadata = map[string]string{nsrIdKey: "nsr-1"}
+	create, err := hand.getNetworkCreate(getVimInstance(), dockerNet)
+	assert.Nil(t, err)
+	existing := types.NetworkResource{
+		Name:   "private_0123abcd",
+		Driver: "bridge",
+		IPAM:   dockerNetwork.IPAM{Config: []dockerNetwork.IPAMConfig{{Subnet: "10.10.0.0/24"}}},
+		Labels: map[string]string{managedLabel: "docker", vimLabel: "TestDocker", nsrIdLabel: "nsr-1"},
+	}
+	obNet, err := hand.getExistingNetwork(existing, create)
+	assert.Nil(t, err)
+	assert.Equal(t, "private_0123abcd", obNet.Name)
+
+	existing.IPAM.Config[0].Subnet = "10.20.0.0/24"
+	_, err = hand.getExistingNetwork(existing, create)
+	assert.NotNil(t, err)
+
+	existing.IPAM.Config[0].Subnet = "10.10.0.0/24"
+	existing.Labels[managedLabel] = "other"
+	_, err = hand.getExistingNetwork(existing, create)
+	assert.NotNil(t, err)
+}
+
+func TestGetNetworkCreateMetadata(t *testing.T) {
+	hand := NewHandlerPlugin(false)
+	dockerNet := &catalogue.DockerNetwork{Subnet: "10.10.0.0/24,fd00:10::/64"}
+	dockerNet.Metadata = map[string]string{
+		"driver":                "macvlan",
+		"opt.parent":            "eth1.100",
+		"ipam-opt.foo":          "bar",
+		"internal":              "true",
+		"attachable":            "false",
+		"ip-range":              "10.10.0.128/25",
+		"aux-address.router":    "10.10.0.254",
+		"aux-address.router-v6": "fd00:10::fe",
+	}
+	create, err := hand.getNetworkCreate(getVimInstance(), dockerNet)
+	assert.Nil(t, err)
+	assert.Equal(t, "macvlan", create.Driver)
+	assert.Equal(t, "eth1.100", create.Options["parent"])
+	assert.Equal(t, "bar", create.IPAM.Options["foo"])
+	assert.True(t, create.Internal)
+	assert.False(t, create.Attachable)
+	assert.True(t, create.EnableIPv6)
+	assert.Equal(t, "10.10.0.128/25", create.IPAM.Config[0].IPRange)
+	assert.Equal(t, "10.10.0.254", create.IPAM.Config[0].AuxAddress["router"])
+	assert.Equal(t, "fd00:10::fe", create.IPAM.Config[1].AuxAddress["router-v6"])
+
+	dockerNet.Metadata["aux-address.router"] = "10.20.0.1"
+	_, err = hand.getNetworkCreate(getVimInstance(), dockerNet)
+	assert.NotNil(t, err)
+}
+
+func TestParseParent(t *testing.T) {
+	iface, vlan, err := parseParent("eth1.100")
+	assert.Nil(t, err)
+	assert.Equal(t, "eth1", iface)
+	assert.Equal(t, 100, vlan)
+
+	iface, vlan, err = parseParent("enp0s3")
+	assert.Nil(t, err)
+	assert.Equal(t, "enp0s3", iface)
+	assert.Equal(t, 0, vlan)
+
+	_, _, err = parseParent("eth1.5000")
+	assert.NotNil(t, err)
+	_, _, err = parseParent("../eth1")
+	assert.NotNil(t, err)
+}
+
+func TestOwnerLabels(t *testing.T) {
+	hand := NewHandlerPlugin(false)
+	labels := hand.getOwnerLabels(getVimInstance(), map[string]string{nsrIdKey: "nsr-1"})
+	assert.Equal(t, map[string]string{managedLabel: "docker", vimLabel: "TestDocker", nsrIdLabel: "nsr-1"}, labels)
+	assert.True(t, hand.isOwned(labels))
+
+	other := NewHandlerPlugin(false)
+	other.DriverID = "docker-edge"
+	assert.False(t, other.isOwned(labels))
+}
+
+func TestGarbageCollectorOrphans(t *testing.T) {
+	dir, err := ioutil.TempDir("", "known")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	known := &FileKnownResources{Path: filepath.Join(dir, "known.json")}
+	_, err = known.Known()
+	assert.NotNil(t, err)
+	assert.Nil(t, ioutil.WriteFile(known.Path, []byte(`["abc", "my-net"]`), 0600))
+	ids, err := known.Known()
+	assert.Nil(t, err)
+	assert.Len(t, ids, 2)
+
+	gc := &GarbageCollector{GracePeriod: time.Minute, Logger: log, orphans: make(map[string]time.Time)}
+	now := time.Now()
+	seen := make(map[string]bool)
+	assert.False(t, gc.isOrphan("abc", "ctr", "container", ids, seen, now))
+	assert.False(t, gc.isOrphan("def", "my-net", "network", ids, seen, now))
+	assert.False(t, gc.isOrphan("ghi", "other", "container", ids, seen, now))
+	assert.True(t, seen["ghi"])
+	assert.False(t, gc.isOrphan("ghi", "other", "container", ids, seen, now.Add(30*time.Second)))
+	assert.True(t, gc.isOrphan("ghi", "other", "container", ids, seen, now.Add(time.Minute)))
+}
+
+func TestClientManager(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", "1.30")
+		fmt.Fprint(w, "OK")
+	}))
+	defer server.Close()
+	instance := &catalogue.DockerVimInstance{}
+	instance.Name = "test"
+	instance.AuthURL = strings.Replace(server.URL, "http://", "tcp://", 1)
+
+	builds := 0
+	var dialed func(net.Conn, error) (net.Conn, error)
+	build := func(d func(net.Conn, error) (net.Conn, error)) (*client.Client, io.Closer, error) {
+		builds++
+		dialed = d
+		cl, err := client.NewClient(instance.AuthURL, "1.36", nil, nil)
+		return cl, nil, err
+	}
+	m := newClientManager()
+	first, err := m.get(context.Background(), instance, log, build)
+	assert.Nil(t, err)
+	second, err := m.get(context.Background(), instance, log, build)
+	assert.Nil(t, err)
+	assert.Equal(t, first, second)
+	assert.Equal(t, 1, builds)
+
+	for i := 0; i < maxFailures; i++ {
+		dialed(nil, fmt.Errorf("connection refused"))
+	}
+	_, err = m.get(context.Background(), instance, log, build)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, builds)
+
+	// another configuration of the same host replaces the client of the previous one
+	other := *instance
+	other.Cert = "changed"
+	_, err = m.get(context.Background(), &other, log, build)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, builds)
+	assert.Len(t, m.clients, 1)
+	_, err = m.get(context.Background(), instance, log, build)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, builds)
+	assert.Len(t, m.clients, 1)
+
+	_, err = m.get(context.Background(), &catalogue.DockerVimInstance{}, log, func(d func(net.Conn, error) (net.Conn, error)) (*client.Client, io.Closer, error) {
+		return nil, nil, fmt.Errorf("invalid host")
+	})
+	assert.NotNil(t, err)
+}
+
+func TestClientManagerContext(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	instance := &catalogue.DockerVimInstance{}
+	instance.Name = "slow"
+	instance.AuthURL = strings.Replace(server.URL, "http://", "tcp://", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := newClientManager().get(ctx, instance, log, func(d func(net.Conn, error) (net.Conn, error)) (*client.Client, io.Closer, error) {
+		cl, err := client.NewClient(instance.AuthURL, "1.36", nil, nil)
+		return cl, nil, err
+	})
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+type countingCloser struct {
+	closes int
+}
+
+func (c *countingCloser) Close() error {
+	c.closes++
+	return nil
+}
+
+func TestRetiredClient(t *testing.T) {
+	cl, err := client.NewClient("tcp://127.0.0.1:2375", "1.36", nil, nil)
+	assert.Nil(t, err)
+	closer := &countingCloser{}
+	c := &managedClient{cl: cl, closer: closer}
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn, err := c.dialed(local, nil)
+	assert.Nil(t, err)
+
+	// the connection is still in use, so the client is closed only once it is done
+	c.retire()
+	assert.Equal(t, 0, closer.closes)
+	assert.Nil(t, conn.Close())
+	assert.Equal(t, 1, closer.closes)
+	conn.Close()
+	assert.Equal(t, 1, closer.closes)
+
+	c.retire()
+	assert.Equal(t, 1, closer.closes)
+
+	c = &managedClient{cl: cl, closer: closer}
+	c.retire()
+	c.retire()
+	assert.Equal(t, 2, closer.closes)
+}
+
+func TestConnectionFailures(t *testing.T) {
+	c := &managedClient{}
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn, err := c.dialed(local, nil)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// failed reads on an established connection count as well as failed dials
+	buf := make([]byte, 1)
+	conn.SetReadDeadline(time.Now().Add(-time.Second))
+	for i := 0; i < maxFailures; i++ {
+		_, err = conn.Read(buf)
+		assert.NotNil(t, err)
+	}
+	assert.False(t, c.healthy())
+
+	conn.SetReadDeadline(time.Time{})
+	go remote.Write([]byte("x"))
+	_, err = conn.Read(buf)
+	assert.Nil(t, err)
+	assert.True(t, c.healthy())
+}
+
+func generateCertificate(t *testing.T, notBefore, notAfter time.Time) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	cert, key := generateCertificate(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	config, err := newTLSConfig("tcp://host:2376", cert, cert, key)
+	assert.Nil(t, err)
+	assert.Len(t, config.Certificates, 1)
+	assert.NotNil(t, config.RootCAs)
+
+	_, err = newTLSConfig("tcp://host:2376", []byte("not a certificate"), cert, key)
+	assert.IsType(t, &CertificateError{}, err)
+	_, err = newTLSConfig("tcp://host:2376", cert, cert, nil)
+	assert.IsType(t, &CertificateError{}, err)
+	expired, expiredKey := generateCertificate(t, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+	_, err = newTLSConfig("tcp://host:2376", cert, expired, expiredKey)
+	assert.IsType(t, &CertificateError{}, err)
+
+	dir, err := ioutil.TempDir("", "certs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	instance := &catalogue.DockerVimInstance{}
+	instance.Name = "vim"
+	instance.AuthURL = "tcp://host:2376"
+	_, _, _, err = readCertDirectory(dir, instance)
+	assert.IsType(t, &CertificateError{}, err)
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "vim"), 0700))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "vim", caFile), cert, 0600))
+	ca, c, k, err := readCertDirectory(dir, instance)
+	assert.Nil(t, err)
+	assert.Equal(t, cert, ca)
+	assert.Empty(t, c)
+	assert.Empty(t, k)
+}
+
+func TestNewSSHDialer(t *testing.T) {
+	_, key := generateCertificate(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	dir, err := ioutil.TempDir("", "ssh")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	knownHosts := filepath.Join(dir, "known_hosts")
+	assert.Nil(t, ioutil.WriteFile(knownHosts, nil, 0600))
+
+	instance := &catalogue.DockerVimInstance{}
+	instance.Name = "vim"
+	instance.AuthURL = "ssh://host"
+	instance.DockerKey = string(key)
+	_, err = newSSHDialer(instance, knownHosts)
+	assert.NotNil(t, err)
+
+	instance.AuthURL = "ssh://docker@host"
+	_, err = newSSHDialer(instance, filepath.Join(dir, "missing"))
+	assert.NotNil(t, err)
+	dialer, err := newSSHDialer(instance, knownHosts)
+	assert.Nil(t, err)
+	assert.Equal(t, "host:22", dialer.addr)
+	assert.Equal(t, defaultRemoteSocket, dialer.socket)
+	assert.Equal(t, "docker", dialer.config.User)
+
+	instance.AuthURL = "ssh://docker@host:2222/run/docker.sock"
+	dialer, err = newSSHDialer(instance, knownHosts)
+	assert.Nil(t, err)
+	assert.Equal(t, "host:2222", dialer.addr)
+	assert.Equal(t, "/run/docker.sock", dialer.socket)
+
+	instance.DockerKey = "not a key"
+	_, err = newSSHDialer(instance, knownHosts)
+	assert.NotNil(t, err)
+}
+
+func TestAPIVersion(t *testing.T) {
+	instance := &catalogue.DockerVimInstance{}
+	instance.Metadata = map[string]string{}
+	version, err := getAPIVersion(instance)
+	assert.Nil(t, err)
+	assert.Equal(t, "", version)
+	instance.Metadata[apiVersionKey] = "v1.24"
+	version, err = getAPIVersion(instance)
+	assert.Nil(t, err)
+	assert.Equal(t, "1.24", version)
+	instance.Tenant = "1.32"
+	version, err = getAPIVersion(instance)
+	assert.Nil(t, err)
+	assert.Equal(t, "1.32", version)
+	instance.Tenant = "latest"
+	_, err = getAPIVersion(instance)
+	assert.NotNil(t, err)
+
+	cl, err := client.NewClient("unix:///var/run/docker.sock", "1.24", nil, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, requireVersion(cl, "swarm mode", swarmAPIVersion))
+	err = requireVersion(cl, "attachable networks", attachableAPIVersion)
+	assert.IsType(t, &UnsupportedError{}, err)
+	assert.Contains(t, err.Error(), "unsupported by engine version 1.24")
+}
+
+func TestBeginTimeout(t *testing.T) {
+	hand := NewHandlerPlugin(false)
+	hand.Timeouts = map[Operation]time.Duration{OperationList: 10 * time.Millisecond}
+	assert.Equal(t, DefaultTimeouts[OperationPull], hand.getTimeout(OperationPull))
+
+	op, done := hand.begin(OperationList)
+	<-op.ctx.Done()
+	err := op.ctx.Err()
+	done(&err)
+	assert.True(t, IsTimeout(err))
+	assert.Nil(t, hand.ctx.Err())
+
+	op, done = hand.begin(OperationCreate)
+	err = fmt.Errorf("no such image")
+	done(&err)
+	assert.False(t, IsTimeout(err))
+	assert.NotNil(t, op.ctx.Err())
+}
+
+func TestParallelOperations(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", "1.30")
+		fmt.Fprint(w, "OK")
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "parallel")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	hand := NewHandlerPlugin(false)
+	hand.Logger = log
+	assert.Nil(t, hand.LoadFlavours(filepath.Join(dir, "flavours.json")))
+	instance := &catalogue.DockerVimInstance{}
+	instance.Name = "parallel"
+	instance.AuthURL = strings.Replace(server.URL, "http://", "tcp://", 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := hand.getClient(instance)
+			assert.Nil(t, err)
+
+			flavour, err := hand.AddFlavour(instance, &catalogue.DeploymentFlavour{FlavourKey: fmt.Sprintf("f%d", i), RAM: 512})
+			assert.Nil(t, err)
+			flavour.RAM = 1024
+			_, err = hand.UpdateFlavour(instance, flavour)
+			assert.Nil(t, err)
+			_, err = hand.ListFlavours(instance)
+			assert.Nil(t, err)
+
+			op, done := hand.begin(OperationList)
+			id := fmt.Sprintf("container%d", i)
+			op.state.tracker.set(id, StatusBuild)
+			op.state.tracker.status(id)
+			op.state.tracker.forget(id)
+			done(nil)
+
+			_, err = hand.DeleteFlavour(instance, flavour.ExtID)
+			assert.Nil(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	flavours, err := hand.ListFlavours(instance)
+	assert.Nil(t, err)
+	assert.Len(t, flavours, 1)
+	assert.Len(t, hand.state.vims.list(), 1)
+}
+
+// fakeDocker is a docker host answering the api calls used by the tests, keeping its state in memory. Its containers
+// run right away unless exit is set.
+type fakeDocker struct {
+	*httptest.Server
+	mu         sync.Mutex
+	exit       bool
+	containers []types.Container
+	images     []types.ImageSummary
+	networks   []types.NetworkResource
+	// networkIDs numbers the networks, which are not numbered by their position since they can be removed
+	networkIDs int
+	// hostConfigs holds the host configuration each container was created with
+	hostConfigs map[string]*container.HostConfig
+}
+
+func fakeDockerHost() *fakeDocker {
+	f := &fakeDocker{
+		images: []types.ImageSummary{{ID: "sha256:image", RepoTags: []string{"image:latest"}}},
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// instance returns a vim instance for the fake host.
+func (f *fakeDocker) instance(name string) *catalogue.DockerVimInstance {
+	instance := &catalogue.DockerVimInstance{}
+	instance.Name = name
+	instance.AuthURL = strings.Replace(f.URL, "http://", "tcp://", 1)
+	return instance
+}
+
+func (f *fakeDocker) container(id string) (int, bool) {
+	for i, c := range f.containers {
+		if c.ID == id {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// network returns the network with the given id or name.
+func (f *fakeDocker) network(ref string) (*types.NetworkResource, bool) {
+	for i := range f.networks {
+		if f.networks[i].ID == ref || f.networks[i].Name == ref {
+			return &f.networks[i], true
+		}
+	}
+	return nil, false
+}
+
+// addNetwork adds a network as if created with the labels, returning its id.
+func (f *fakeDocker) addNetwork(name string, labels map[string]string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.networkIDs++
+	id := fmt.Sprintf("%064d", 1000+f.networkIDs)
+	f.networks = append(f.networks, types.NetworkResource{
+		ID:         id,
+		Name:       name,
+		Driver:     "bridge",
+		Labels:     labels,
+		Options:    make(map[string]string),
+		Containers: make(map[string]types.EndpointResource),
+	})
+	return id
+}
+
+func (f *fakeDocker) serveNetworks(w http.ResponseWriter, r *http.Request, parts []string) {
+	if len(parts) == 1 {
+		json.NewEncoder(w).Encode(f.networks)
+		return
+	}
+	if parts[1] == "create" {
+		var req struct {
+			types.NetworkCreate
+			Name string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if _, ok := f.network(req.Name); ok {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprintf(w, `{"message": "network with name %s already exists"}`, req.Name)
+			return
+		}
+		f.networkIDs++
+		id := fmt.Sprintf("%064d", 1000+f.networkIDs)
+		res := types.NetworkResource{
+			ID:         id,
+			Name:       req.Name,
+			Driver:     req.Driver,
+			Labels:     req.Labels,
+			Options:    req.Options,
+			Internal:   req.Internal,
+			Attachable: req.Attachable,
+			Containers: make(map[string]types.EndpointResource),
+		}
+		if req.IPAM != nil {
+			res.IPAM = *req.IPAM
+		}
+		f.networks = append(f.networks, res)
+		json.NewEncoder(w).Encode(types.NetworkCreateResponse{ID: id})
+		return
+	}
+	n, ok := f.network(parts[1])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"message": "network %s not found"}`, parts[1])
+		return
+	}
+	switch {
+	case len(parts) == 2 && r.Method == "DELETE":
+		if len(n.Containers) > 0 {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, `{"message": "network %s has active endpoints"}`, n.Name)
+			return
+		}
+		for i := range f.networks {
+			if f.networks[i].ID == n.ID {
+				f.networks = append(f.networks[:i], f.networks[i+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 2:
+		json.NewEncoder(w).Encode(n)
+	case len(parts) == 3 && (parts[2] == "connect" || parts[2] == "disconnect"):
+		var req struct {
+			Container string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, c := range f.containers {
+			if c.ID != req.Container && c.Names[0] != "/"+req.Container {
+				continue
+			}
+			_, attached := n.Containers[c.ID]
+			if parts[2] == "connect" && attached || parts[2] == "disconnect" && !attached {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprintf(w, `{"message": "container %s cannot %s"}`, req.Container, parts[2])
+				return
+			}
+			if parts[2] == "connect" {
+				n.Containers[c.ID] = types.EndpointResource{Name: strings.TrimPrefix(c.Names[0], "/")}
+			} else {
+				delete(n.Containers, c.ID)
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"message": "No such container: %s"}`, req.Container)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeDocker) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("API-Version", "1.30")
+	path := r.URL.Path
+	if strings.HasPrefix(path, "/v1.") {
+		path = path[strings.Index(path[1:], "/")+1:]
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if path == "/images/create" {
+		// the pull of slow images never ends, the others are pulled right away
+		fmt.Fprintf(w, `{"status": "Pulling from %s"}`+"\n", r.URL.Query().Get("fromImage"))
+		w.(http.Flusher).Flush()
+		if strings.HasPrefix(r.URL.Query().Get("fromImage"), "slow") {
+			<-r.Context().Done()
+		}
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case path == "/_ping":
+		fmt.Fprint(w, "OK")
+	case path == "/containers/create":
+		name := r.URL.Query().Get("name")
+		for _, c := range f.containers {
+			if c.Names[0] == "/"+name {
+				w.WriteHeader(http.StatusConflict)
+				fmt.Fprintf(w, `{"message": "name %s in use"}`, name)
+				return
+			}
+		}
+		var req struct {
+			container.Config
+			HostConfig *container.HostConfig
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		labels := req.Labels
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		id := fmt.Sprintf("%064d", len(f.containers)+1)
+		f.containers = append(f.containers, types.Container{
+			ID:              id,
+			Names:           []string{"/" + name},
+			Image:           "image:latest",
+			ImageID:         "sha256:image",
+			State:           "created",
+			Status:          "Created",
+			Labels:          labels,
+			NetworkSettings: &types.SummaryNetworkSettings{},
+		})
+		if f.hostConfigs == nil {
+			f.hostConfigs = make(map[string]*container.HostConfig)
+		}
+		f.hostConfigs[id] = req.HostConfig
+		json.NewEncoder(w).Encode(container.ContainerCreateCreatedBody{ID: id})
+	case path == "/containers/json":
+		args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		res := make([]types.Container, 0)
+		for _, c := range f.containers {
+			if ids := args.Get("id"); len(ids) > 0 && !stringEqualInSlice(c.ID, ids) {
+				continue
+			}
+			if !hasLabels(c.Labels, args.Get("label")) {
+				continue
+			}
+			if c.State == "running" || r.URL.Query().Get("all") == "1" {
+				res = append(res, c)
+			}
+		}
+		json.NewEncoder(w).Encode(res)
+	case path == "/images/json":
+		json.NewEncoder(w).Encode(f.images)
+	case path == "/info":
+		json.NewEncoder(w).Encode(types.Info{NCPU: 4, MemTotal: 8 * 1024 * 1024 * 1024})
+	case parts[0] == "networks":
+		f.serveNetworks(w, r, parts)
+	case len(parts) == 3 && parts[0] == "images" && parts[2] == "json":
+		for _, img := range f.images {
+			if img.ID == parts[1] {
+				json.NewEncoder(w).Encode(types.ImageInspect{ID: img.ID, RepoTags: img.RepoTags})
+				return
+			}
+		}
+		http.NotFound(w, r)
+	case len(parts) >= 2 && parts[0] == "containers":
+		i, ok := f.container(parts[1])
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"message": "No such container: %s"}`, parts[1])
+			return
+		}
+		c := &f.containers[i]
+		switch {
+		case len(parts) == 2 && r.Method == "DELETE":
+			f.containers = append(f.containers[:i], f.containers[i+1:]...)
+			delete(f.hostConfigs, c.ID)
+			w.WriteHeader(http.StatusNoContent)
+		case len(parts) == 3 && parts[2] == "start":
+			c.State, c.Status = "running", "Up"
+			if f.exit {
+				c.State, c.Status = "exited", "Exited (1)"
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case len(parts) == 3 && parts[2] == "stop":
+			c.State, c.Status = "exited", "Exited (0)"
+			w.WriteHeader(http.StatusNoContent)
+		case len(parts) == 3 && parts[2] == "json":
+			hostConfig := f.hostConfigs[c.ID]
+			if hostConfig == nil {
+				hostConfig = &container.HostConfig{}
+			}
+			json.NewEncoder(w).Encode(types.ContainerJSON{
+				ContainerJSONBase: &types.ContainerJSONBase{
+					ID:         c.ID,
+					Name:       c.Names[0],
+					Image:      c.ImageID,
+					State:      &types.ContainerState{Running: c.State == "running", Status: c.State},
+					HostConfig: hostConfig,
+				},
+				Config:          &container.Config{Image: c.Image, Labels: c.Labels},
+				NetworkSettings: &types.NetworkSettings{},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// hasLabels tells whether the labels match all the label filters, either key or key=value.
+func hasLabels(labels map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		parts := strings.SplitN(filter, "=", 2)
+		val, ok := labels[parts[0]]
+		if !ok || len(parts) == 2 && val != parts[1] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParallelLaunches(t *testing.T) {
+	server := fakeDockerHost()
+	defer server.Close()
+	instance := server.instance("launches")
+
+	hand := NewHandlerPlugin(false)
+	hand.Logger = log
+	ids := make(chan string, 20)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			srv, err := hand.LaunchInstance(instance, fmt.Sprintf("launch%d", i), "image:latest", defaultFlavour.FlavourKey, "", nil, nil, "")
+			if !assert.Nil(t, err) {
+				return
+			}
+			ids <- srv.ExtID
+			_, err = hand.ListServer(instance)
+			assert.Nil(t, err)
+			_, err = hand.ListFlavours(instance)
+			assert.Nil(t, err)
+
+			op, done := hand.begin(OperationList)
+			_, err = op.getClient(instance)
+			done(&err)
+			assert.Nil(t, err)
+			op.state.tracker.status(srv.ExtID)
+		}(i)
+	}
+	wg.Wait()
+	close(ids)
+
+	// the background waits of the launches end with the containers running, which are not tracked anymore
+	for id := range ids {
+		deadline := time.Now().Add(10 * time.Second)
+		_, tracked := hand.state.tracker.status(id)
+		for tracked && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			_, tracked = hand.state.tracker.status(id)
+		}
+		assert.False(t, tracked)
+	}
+	assert.Len(t, hand.state.vims.list(), 1)
+}
+
+func TestHandlerWithoutState(t *testing.T) {
+	server := fakeDockerHost()
+	defer server.Close()
+	instance := server.instance("stateless")
+
+	// the methods work on copies of the handler, only NewHandlerPlugin gives them a state to share
+	hand := PluginImpl{Logger: log}
+	_, err := hand.LaunchInstance(instance, "launch", "image:latest", "", "", nil, nil, "")
+	assert.Equal(t, errNoState, err)
+	_, err = hand.AddFlavour(instance, &catalogue.DeploymentFlavour{FlavourKey: "small"})
+	assert.Equal(t, errNoState, err)
+	assert.Equal(t, errNoState, hand.LoadFlavours("flavours.json"))
+	server.mu.Lock()
+	assert.Empty(t, server.containers)
+	server.mu.Unlock()
+}
+
+
+func TestListServer(t *testing.T) {
+	server := fakeDockerHost()
+	defer server.Close()
+	instance := server.instance("list")
+	hand := NewHandlerPlugin(false)
+	hand.Logger = log
+
+	_, err := hand.LaunchInstanceAndWait(instance, "listed", "image:latest", "", "", nil, nil, "")
+	assert.Nil(t, err)
+	servers, err := hand.ListServer(instance)
+	assert.Nil(t, err)
+	if assert.Len(t, servers, 1) {
+		assert.Equal(t, "/listed", servers[0].Name)
+		assert.Equal(t, []string{"image:latest"}, servers[0].Image.(*catalogue.DockerImage).Tags)
+	}
+
+	// the image of the container is gone: an error, not a panic
+	server.mu.Lock()
+	server.images = nil
+	server.mu.Unlock()
+	_, err = hand.ListServer(instance)
+	assert.NotNil(t, err)
+}
+
+func TestLaunchTracker(t *testing.T) {
+	tracker := newLaunchTracker()
+	started := make(chan struct{})
+	tracker.track(log, nil, "ok", func(*client.Client, string) error {
+		<-started
+		return nil
+	})
+	status, ok := tracker.status("ok")
+	assert.True(t, ok)
+	assert.Equal(t, StatusBuild, status)
+	close(started)
+	tracker.track(log, nil, "failed", func(*client.Client, string) error {
+		return fmt.Errorf("exited")
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		_, running := tracker.status("ok")
+		status, _ = tracker.status("failed")
+		if !running && status == StatusError {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, ok = tracker.status("ok")
+	assert.False(t, ok)
+	status, _ = tracker.status("failed")
+	assert.Equal(t, StatusError, status)
+}
+
+func TestAddImageFromURL(t *testing.T) {
+	server := fakeDockerHost()
+	defer server.Close()
+	instance := server.instance("pull")
+	hand := NewHandlerPlugin(false)
+	hand.Logger = log
+	hand.Timeouts = map[Operation]time.Duration{OperationPull: 100 * time.Millisecond}
+
+	img, err := hand.AddImageFromURL(instance, &catalogue.DockerImage{}, "image:latest")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"image:latest"}, img.(*catalogue.DockerImage).Tags)
+
+	_, err = hand.AddImageFromURL(instance, &catalogue.DockerImage{}, "missing:latest")
+	assert.NotNil(t, err)
+
+	_, err = hand.AddImageFromURL(instance, &catalogue.DockerImage{}, "slow:latest")
+	assert.True(t, IsTimeout(err))
+}
+
+func TestLaunchAndWaitExited(t *testing.T) {
+	server := fakeDockerHost()
+	defer server.Close()
+	instance := server.instance("exited")
+	hand := NewHandlerPlugin(false)
+	hand.Logger = log
+
+	server.mu.Lock()
+	server.exit = true
+	server.mu.Unlock()
+	_, err := hand.LaunchInstanceAndWait(instance, "exiting", "image:latest", "", "", nil, nil, "")
+	assert.NotNil(t, err)
+	server.mu.Lock()
+	assert.Empty(t, server.containers)
+	// the name is free again for the retry
+	server.exit = false
+	server.mu.Unlock()
+	srv, err := hand.LaunchInstanceAndWait(instance, "exiting", "image:latest", "", "", nil, nil, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "/exiting", srv.Name)
+}
+
+func TestWaitForRemovalTimeout(t *testing.T) {
+	server := fakeDockerHost()
+	defer server.Close()
+	instance := server.instance("removal")
+	hand := NewHandlerPlugin(false)
+	hand.Logger = log
+	hand.Timeouts = map[Operation]time.Duration{OperationDelete: 100 * time.Millisecond}
+
+	srv, err := hand.LaunchInstanceAndWait(instance, "staying", "image:latest", "", "", nil, nil, "")
+	assert.Nil(t, err)
+
+	// the container is never removed, the wait ends with the operation instead of polling on
+	start := time.Now()
+	h, done := hand.begin(OperationDelete)
+	cl, err := h.getClient(instance)
+	if assert.Nil(t, err) {
+		err = h.waitForRemoval(cl, srv.ExtID)
+	}
+	done(&err)
+	_, ok := err.(*TimeoutError)
+	assert.True(t, ok)
+	assert.True(t, time.Since(start) < launchPollInterval)
+}
+
+func TestQuota(t *testing.T) {
+	server := fakeDockerHost()
+	defer server.Close()
+	instance := server.instance("quota")
+	hand := NewHandlerPlugin(false)
+	hand.Logger = log
+	hand.DriverID = "docker"
+
+	flavour, err := hand.AddFlavour(instance, &catalogue.DeploymentFlavour{FlavourKey: "medium", VCPUs: 2, RAM: 1024})
+	assert.Nil(t, err)
+	_, err = hand.LaunchInstanceAndWait(instance, "limited", "image:latest", flavour.FlavourKey, "", nil, nil, "")
+	assert.Nil(t, err)
+	_, err = hand.LaunchInstanceAndWait(instance, "unlimited", "image:latest", "", "", nil, nil, "")
+	assert.Nil(t, err)
+	// containers of other drivers and stopped ones reserve nothing
+	server.mu.Lock()
+	server.containers = append(server.containers, types.Container{
+		ID:     "other",
+		Names:  []string{"/other"},
+		State:  "running",
+		Labels: map[string]string{managedLabel: "other"},
+	})
+	server.hostConfigs["other"] = &container.HostConfig{Resources: container.Resources{NanoCPUs: 1e9}}
+	server.mu.Unlock()
+	stopped, err := hand.LaunchInstanceAndWait(instance, "stopped", "image:latest", flavour.FlavourKey, "", nil, nil, "")
+	assert.Nil(t, err)
+	server.mu.Lock()
+	i, _ := server.container(stopped.ExtID)
+	server.containers[i].State = "exited"
+	server.mu.Unlock()
+
+	h, done := hand.begin(OperationList)
+	cl, err := h.getClient(instance)
+	assert.Nil(t, err)
+	reserved, instances, err := h.getReservedCapacity(cl)
+	done(&err)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, instances)
+	assert.Equal(t, int64(2e9), reserved.nanoCPUs)
+	assert.Equal(t, int64(1024*1024*1024), reserved.memory)
+
+	quota, err := hand.Quota(instance)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, quota.Cores)
+	assert.Equal(t, 7*1024, quota.RAM)
+	assert.Equal(t, unlimitedQuota, quota.Instances)
+}
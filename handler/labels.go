@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"docker.io/go-docker/api/types/filters"
+	"github.com/openbaton/go-openbaton/catalogue"
+)
+
+// labels set on the networks and containers created by the driver
+const (
+	// managedLabel holds the id of the driver that created the resource, only those resources are changed by it
+	managedLabel = "org.openbaton.driver"
+	vimLabel     = "org.openbaton.vim"
+	nsrIdLabel   = "org.openbaton.nsr-id"
+	vnfrIdLabel  = "org.openbaton.vnfr-id"
+	// reservedLabelPrefix starts the labels of the driver, which cannot be set or unset through the metadata
+	reservedLabelPrefix = "org.openbaton."
+)
+
+// ownedOnlyKey is the vim instance metadata key restricting the listed networks and containers to those created by the
+// driver
+const ownedOnlyKey = "owned-only"
+
+var defaultDriverID = "docker"
+
+func (h PluginImpl) getDriverID() string {
+	if h.DriverID == "" {
+		return defaultDriverID
+	}
+	return h.DriverID
+}
+
+// getOwnerLabels returns the labels marking a resource as created by the driver for the vim instance, metadata may
+// contain the ids of the records the resource belongs to.
+func (h PluginImpl) getOwnerLabels(instance *catalogue.DockerVimInstance, metadata map[string]string) map[string]string {
+	res := map[string]string{
+		managedLabel: h.getDriverID(),
+	}
+	if instance != nil && instance.Name != "" {
+		res[vimLabel] = instance.Name
+	}
+	if val := metadata[nsrIdKey]; val != "" {
+		res[nsrIdLabel] = val
+	}
+	if val := metadata[vnfrIdKey]; val != "" {
+		res[vnfrIdLabel] = val
+	}
+	return res
+}
+
+// isOwned tells whether the labels belong to a resource created by this driver.
+func (h PluginImpl) isOwned(labels map[string]string) bool {
+	return labels[managedLabel] == h.getDriverID()
+}
+
+// getOwnedFilter returns the filter selecting the resources created by this driver.
+func (h PluginImpl) getOwnedFilter() filters.Args {
+	return filters.NewArgs(filters.Arg("label", managedLabel+"="+h.getDriverID()))
+}
+
+// getListFilter returns the filter to apply when listing for the vim instance, empty unless the ownedOnlyKey metadata is
+// true.
+func (h PluginImpl) getListFilter(instance *catalogue.DockerVimInstance) filters.Args {
+	if instance.Metadata[ownedOnlyKey] == "true" {
+		return h.getOwnedFilter()
+	}
+	return filters.NewArgs()
+}
+
+// checkLabel fails if the label is reserved for the driver.
+func checkLabel(key string) error {
+	if strings.HasPrefix(key, reservedLabelPrefix) {
+		return errors.New(fmt.Sprintf("label %s is reserved for the driver, %s* labels cannot be changed", key, reservedLabelPrefix))
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"docker.io/go-docker"
+	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/container"
+	"docker.io/go-docker/api/types/strslice"
+	"github.com/openbaton/go-openbaton/catalogue"
+)
+
+// helperImageKey is the vim instance metadata key with the image used to inspect the host interfaces, it needs cat
+const helperImageKey = "helper-image"
+
+var defaultHelperImage = "busybox:latest"
+
+var interfaceNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,15}$`)
+
+// isHostInterfaceDriver tells whether the network driver attaches the containers directly to a host interface.
+func isHostInterfaceDriver(driver string) bool {
+	return driver == "macvlan" || driver == "ipvlan"
+}
+
+// parseParent splits the parent of a macvlan or ipvlan network into the host interface and the 802.1q vlan id, which is
+// 0 if the parent is not a sub-interface.
+func parseParent(parent string) (string, int, error) {
+	iface := parent
+	vlan := 0
+	if i := strings.LastIndex(parent, "."); i >= 0 {
+		iface = parent[:i]
+		id, err := strconv.Atoi(parent[i+1:])
+		if err != nil || id < 1 || id > 4094 {
+			return "", 0, errors.New(fmt.Sprintf("parent %s has an invalid vlan id, must be between 1 and 4094", parent))
+		}
+		vlan = id
+	}
+	if !interfaceNameRegexp.MatchString(iface) {
+		return "", 0, errors.New(fmt.Sprintf("parent %s is not a valid interface name", parent))
+	}
+	return iface, vlan, nil
+}
+
+// validateParent checks that the interface the network is attached to exists on the docker host and that no other
+// network, apart from the one with id self, uses the same parent with overlapping subnets. Docker creates the vlan
+// sub-interface by itself, so only the base interface has to exist.
+func (h PluginImpl) validateParent(cl *docker.Client, instance *catalogue.DockerVimInstance, create types.NetworkCreate, self string) error {
+	parent, ok := create.Options["parent"]
+	if !ok || parent == "" {
+		return nil
+	}
+	iface, vlan, err := parseParent(parent)
+	if err != nil {
+		return err
+	}
+	nets, err := cl.NetworkList(h.ctx, types.NetworkListOptions{})
+	if err != nil {
+		h.Logger.Errorf("Error listing networks: %v", err)
+		return err
+	}
+	for _, n := range listParentNetworks(nets, parent) {
+		if n.ID == self {
+			continue
+		}
+		for _, pool := range create.IPAM.Config {
+			if err = checkOverlap(pool.Subnet, n.IPAM.Config); err != nil {
+				return errors.New(fmt.Sprintf("network %s on parent %s: %v", n.Name, parent, err))
+			}
+		}
+	}
+	image := defaultHelperImage
+	if val, ok := instance.Metadata[helperImageKey]; ok && val != "" {
+		image = val
+	}
+	exists, err := h.hostInterfaceExists(cl, instance, image, iface)
+	if err != nil {
+		h.Logger.Errorf("Not able to check interface %s on the docker host: %v", iface, err)
+		return err
+	}
+	if !exists {
+		return errors.New(fmt.Sprintf("interface %s does not exist on the docker host", iface))
+	}
+	h.Logger.Debugf("Parent interface %s exists, vlan %d", iface, vlan)
+	return nil
+}
+
+// hostInterfaceExists runs a short lived container in the host network namespace looking for the interface.
+func (h PluginImpl) hostInterfaceExists(cl *docker.Client, instance *catalogue.DockerVimInstance, image, iface string) (bool, error) {
+	if _, err := getImagesByName(cl, h.ctx, image); err != nil {
+		h.Logger.Noticef("Pulling helper image %s", image)
+		out, err := cl.ImagePull(h.ctx, image, types.ImagePullOptions{})
+		if err != nil {
+			return false, err
+		}
+		_, err = ioutil.ReadAll(out)
+		out.Close()
+		if err != nil {
+			return false, err
+		}
+	}
+	resp, err := cl.ContainerCreate(h.ctx, &container.Config{
+		Image:  image,
+		Cmd:    strslice.StrSlice{"cat", "/sys/class/net/" + iface + "/ifindex"},
+		Labels: h.getOwnerLabels(instance, nil),
+	}, &container.HostConfig{
+		NetworkMode: "host",
+	}, nil, "")
+	if err != nil {
+		return false, err
+	}
+	defer h.removeContainer(cl, resp.ID)
+	if err = cl.ContainerStart(h.ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return false, err
+	}
+	statusCh, errCh := cl.ContainerWait(h.ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err = <-errCh:
+		return false, err
+	case status := <-statusCh:
+		return status.StatusCode == 0, nil
+	}
+}
+
+// listParentNetworks returns the macvlan and ipvlan networks attached to the given parent.
+func listParentNetworks(nets []types.NetworkResource, parent string) []types.NetworkResource {
+	res := make([]types.NetworkResource, 0)
+	for _, n := range nets {
+		if isHostInterfaceDriver(n.Driver) && n.Options["parent"] == parent {
+			res = append(res, n)
+		}
+	}
+	return res
+}
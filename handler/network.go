@@ -0,0 +1,637 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"docker.io/go-docker"
+	"docker.io/go-docker/api/types"
+	dockerNetwork "docker.io/go-docker/api/types/network"
+	"github.com/openbaton/go-openbaton/catalogue"
+)
+
+const (
+	// nsrIdKey and vnfrIdKey are the network metadata entries identifying the records the network belongs to
+	nsrIdKey  = "nsr-id"
+	vnfrIdKey = "vnfr-id"
+)
+
+// hasRecordIDs tells whether the records the network belongs to are known, only then a repeated request for the same
+// network is recognised.
+func hasRecordIDs(dockerNet *catalogue.DockerNetwork) bool {
+	return dockerNet.Metadata[nsrIdKey] != "" || dockerNet.Metadata[vnfrIdKey] != ""
+}
+
+// getNetworkName returns the name of the docker network for the logical network. If the records it belongs to are
+// known, the name is the same every time the network is requested for the same records, otherwise it is unique. The
+// suffix keeps networks with the same name of different records apart.
+func getNetworkName(dockerNet *catalogue.DockerNetwork) (string, error) {
+	if !hasRecordIDs(dockerNet) {
+		b := make([]byte, 4)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s_%s", dockerNet.Name, hex.EncodeToString(b)), nil
+	}
+	sum := sha256.Sum256([]byte(dockerNet.Metadata[nsrIdKey] + "/" + dockerNet.Metadata[vnfrIdKey] + "/" + dockerNet.Name))
+	return fmt.Sprintf("%s_%s", dockerNet.Name, hex.EncodeToString(sum[:])[:8]), nil
+}
+
+// getExistingNetwork returns the network created before for the same records, failing if it was not created by the
+// driver or differs from the requested one.
+func (h PluginImpl) getExistingNetwork(existing types.NetworkResource, create types.NetworkCreate) (*catalogue.DockerNetwork, error) {
+	if !h.isOwned(existing.Labels) {
+		return nil, errors.New(fmt.Sprintf("network %s already exists and was not created by the driver", existing.Name))
+	}
+	if len(create.IPAM.Config) == 0 {
+		create.IPAM.Config = existing.IPAM.Config
+		create.EnableIPv6 = existing.EnableIPv6
+	}
+	if changes := getNetworkChanges(existing, create); len(changes) > 0 {
+		return nil, errors.New(fmt.Sprintf("network %s already exists with different %s", existing.Name, strings.Join(changes, ", ")))
+	}
+	h.Logger.Infof("Network [%s] already exists with ext id [%s]", existing.Name, existing.ID)
+	return GetNetwork(existing)
+}
+
+// dockerGeneratedOptions are set by docker itself on the networks
+var dockerGeneratedOptions = map[string]bool{
+	"com.docker.network.driver.overlay.vxlanid_list": true,
+}
+
+// network metadata entries translated into the options of the docker network, see the README
+const (
+	driverKey        = "driver"
+	ipamDriverKey    = "ipam-driver"
+	labelPrefix      = "label."
+	optPrefix        = "opt."
+	ipamOptPrefix    = "ipam-opt."
+	internalKey      = "internal"
+	attachableKey    = "attachable"
+	ipRangeKey       = "ip-range"
+	auxAddressPrefix = "aux-address."
+)
+
+// network metadata entries read by UpdateNetwork only, see the README
+const (
+	// containersKey lists the attached containers in the networks returned by the driver, it is ignored in updates
+	containersKey = "containers"
+	attachKey     = "attach"
+	detachKey     = "detach"
+	// unsetKey lists the label.*, opt.*, ipam-opt.* and aux-address.* entries to remove
+	unsetKey = "unset"
+)
+
+// splitList returns the non empty elements of the comma separated list.
+func splitList(list string) []string {
+	res := make([]string, 0)
+	for _, elem := range strings.Split(list, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			res = append(res, elem)
+		}
+	}
+	return res
+}
+
+// getNetworkCreate translates the network into the options to create the docker network for the vim instance.
+func (h PluginImpl) getNetworkCreate(instance *catalogue.DockerVimInstance, dockerNet *catalogue.DockerNetwork) (types.NetworkCreate, error) {
+	var driver string
+	if h.Swarm {
+		driver = "overlay"
+	} else if val, ok := dockerNet.Metadata[driverKey]; ok {
+		driver = val
+	} else {
+		driver = "bridge"
+	}
+
+	var ipam = &dockerNetwork.IPAM{
+		Options: make(map[string]string),
+	}
+	if val, ok := dockerNet.Metadata[ipamDriverKey]; ok {
+		ipam.Driver = val
+	}
+	if dockerNet.Subnet != "" {
+		ipamConfig, err := getIPAMConfigs(dockerNet.Subnet)
+		if err != nil {
+			return types.NetworkCreate{}, err
+		}
+		ipam.Config = ipamConfig
+	}
+	create := types.NetworkCreate{
+		CheckDuplicate: true,
+		IPAM:           ipam,
+		Driver:         driver,
+		EnableIPv6:     hasIPv6Pool(ipam.Config),
+		Options:        make(map[string]string),
+		Labels:         h.getOwnerLabels(instance, dockerNet.Metadata),
+	}
+	for key, val := range dockerNet.Metadata {
+		var err error
+		switch {
+		case strings.HasPrefix(key, labelPrefix):
+			label := strings.TrimPrefix(key, labelPrefix)
+			if err = checkLabel(label); err == nil {
+				create.Labels[label] = val
+			}
+		case strings.HasPrefix(key, optPrefix):
+			create.Options[strings.TrimPrefix(key, optPrefix)] = val
+		case strings.HasPrefix(key, ipamOptPrefix):
+			ipam.Options[strings.TrimPrefix(key, ipamOptPrefix)] = val
+		case key == internalKey:
+			create.Internal, err = strconv.ParseBool(val)
+		case key == attachableKey:
+			create.Attachable, err = strconv.ParseBool(val)
+		case key == ipRangeKey:
+			for _, ipRange := range strings.Split(val, ",") {
+				if err = setPoolValue(ipam.Config, strings.TrimSpace(ipRange), "", true); err != nil {
+					break
+				}
+			}
+		case strings.HasPrefix(key, auxAddressPrefix):
+			err = setPoolValue(ipam.Config, val, strings.TrimPrefix(key, auxAddressPrefix), false)
+		}
+		if err != nil {
+			return types.NetworkCreate{}, errors.New(fmt.Sprintf("metadata %s=%s: %v", key, val, err))
+		}
+	}
+	return create, nil
+}
+
+// setNetworkOptionsMetadata writes the options of the network into the metadata entries getNetworkCreate translates them
+// from, so that a network read back from the driver is created again the same way. The labels reserved for the driver
+// are left out, but for the ids of the records.
+func setNetworkOptionsMetadata(networkResource types.NetworkResource, metadata map[string]string) {
+	for key, val := range networkResource.Labels {
+		switch key {
+		case managedLabel, vimLabel:
+		case nsrIdLabel:
+			metadata[nsrIdKey] = val
+		case vnfrIdLabel:
+			metadata[vnfrIdKey] = val
+		default:
+			if checkLabel(key) == nil {
+				metadata[labelPrefix+key] = val
+			}
+		}
+	}
+	for key, val := range networkResource.Options {
+		if !dockerGeneratedOptions[key] {
+			metadata[optPrefix+key] = val
+		}
+	}
+	for key, val := range networkResource.IPAM.Options {
+		metadata[ipamOptPrefix+key] = val
+	}
+	if networkResource.Internal {
+		metadata[internalKey] = "true"
+	}
+	if networkResource.Attachable {
+		metadata[attachableKey] = "true"
+	}
+	ipRanges := make([]string, 0)
+	for _, pool := range networkResource.IPAM.Config {
+		if pool.IPRange != "" {
+			ipRanges = append(ipRanges, pool.IPRange)
+		}
+		for name, address := range pool.AuxAddress {
+			metadata[auxAddressPrefix+name] = address
+		}
+	}
+	if len(ipRanges) > 0 {
+		metadata[ipRangeKey] = strings.Join(ipRanges, ",")
+	}
+}
+
+// keepUnsentSettings copies into desired the settings of the live network that the metadata of the update does not
+// mention, so that an update only changes what the caller sent, and removes those listed in the unsetKey entry.
+func (h PluginImpl) keepUnsentSettings(live types.NetworkResource, desired *types.NetworkCreate, metadata map[string]string) error {
+	if _, ok := metadata[driverKey]; !ok && !h.Swarm {
+		desired.Driver = live.Driver
+	}
+	if _, ok := metadata[ipamDriverKey]; !ok {
+		desired.IPAM.Driver = live.IPAM.Driver
+	}
+	if _, ok := metadata[internalKey]; !ok {
+		desired.Internal = live.Internal
+	}
+	if _, ok := metadata[attachableKey]; !ok {
+		desired.Attachable = live.Attachable
+	}
+	for key, val := range live.Labels {
+		if _, ok := desired.Labels[key]; !ok {
+			desired.Labels[key] = val
+		}
+	}
+	for key, val := range live.Options {
+		if _, ok := desired.Options[key]; !ok && !dockerGeneratedOptions[key] {
+			desired.Options[key] = val
+		}
+	}
+	for key, val := range live.IPAM.Options {
+		if _, ok := desired.IPAM.Options[key]; !ok {
+			desired.IPAM.Options[key] = val
+		}
+	}
+	_, ipRangeSent := metadata[ipRangeKey]
+	auxAddressSent := false
+	for key := range metadata {
+		if strings.HasPrefix(key, auxAddressPrefix) {
+			auxAddressSent = true
+		}
+	}
+	for i := range desired.IPAM.Config {
+		for _, pool := range live.IPAM.Config {
+			if pool.Subnet != desired.IPAM.Config[i].Subnet {
+				continue
+			}
+			if !ipRangeSent {
+				desired.IPAM.Config[i].IPRange = pool.IPRange
+			}
+			if !auxAddressSent {
+				desired.IPAM.Config[i].AuxAddress = pool.AuxAddress
+			}
+		}
+	}
+	for _, key := range splitList(metadata[unsetKey]) {
+		if _, ok := metadata[key]; ok {
+			return errors.New(fmt.Sprintf("metadata %s is both set and unset", key))
+		}
+		switch {
+		case strings.HasPrefix(key, labelPrefix):
+			label := strings.TrimPrefix(key, labelPrefix)
+			if err := checkLabel(label); err != nil {
+				return err
+			}
+			delete(desired.Labels, label)
+		case strings.HasPrefix(key, optPrefix):
+			delete(desired.Options, strings.TrimPrefix(key, optPrefix))
+		case strings.HasPrefix(key, ipamOptPrefix):
+			delete(desired.IPAM.Options, strings.TrimPrefix(key, ipamOptPrefix))
+		case strings.HasPrefix(key, auxAddressPrefix):
+			for i := range desired.IPAM.Config {
+				delete(desired.IPAM.Config[i].AuxAddress, strings.TrimPrefix(key, auxAddressPrefix))
+			}
+		default:
+			return errors.New(fmt.Sprintf("metadata %s cannot be unset, only %s*, %s*, %s* and %s* entries can", key, labelPrefix, optPrefix, ipamOptPrefix, auxAddressPrefix))
+		}
+	}
+	return nil
+}
+
+// setPoolValue sets either the ip range or the auxiliary address with the given name on the pool the value belongs to.
+func setPoolValue(pools []dockerNetwork.IPAMConfig, value, auxName string, ipRange bool) error {
+	var ip net.IP
+	if ipRange {
+		rangeIP, _, err := net.ParseCIDR(value)
+		if err != nil {
+			return err
+		}
+		ip = rangeIP
+	} else if ip = net.ParseIP(value); ip == nil {
+		return errors.New(fmt.Sprintf("%s is not a valid ip address", value))
+	}
+	for i := range pools {
+		_, subnet, err := net.ParseCIDR(pools[i].Subnet)
+		if err != nil || !subnet.Contains(ip) {
+			continue
+		}
+		if ipRange {
+			pools[i].IPRange = value
+		} else {
+			if pools[i].AuxAddress == nil {
+				pools[i].AuxAddress = make(map[string]string)
+			}
+			pools[i].AuxAddress[auxName] = value
+		}
+		return nil
+	}
+	return errors.New(fmt.Sprintf("%s is not part of any subnet", value))
+}
+
+// getNetworkChanges returns what differs between the live network and the desired one, docker networks need to be
+// recreated for any of them.
+func getNetworkChanges(live types.NetworkResource, desired types.NetworkCreate) []string {
+	res := make([]string, 0)
+	if live.Driver != desired.Driver {
+		res = append(res, "driver")
+	}
+	if desired.IPAM.Driver != "" && live.IPAM.Driver != desired.IPAM.Driver {
+		res = append(res, "ipam driver")
+	}
+	if !reflect.DeepEqual(getSubnets(live.IPAM.Config), getSubnets(desired.IPAM.Config)) {
+		res = append(res, "subnets")
+	}
+	if live.EnableIPv6 != desired.EnableIPv6 {
+		res = append(res, "ipv6")
+	}
+	if live.Internal != desired.Internal {
+		res = append(res, "internal")
+	}
+	if live.Attachable != desired.Attachable {
+		res = append(res, "attachable")
+	}
+	if !equalMaps(live.IPAM.Options, desired.IPAM.Options) {
+		res = append(res, "ipam options")
+	}
+	if !equalMaps(live.Labels, desired.Labels) {
+		res = append(res, "labels")
+	}
+	liveOptions := make(map[string]string)
+	for key, val := range live.Options {
+		if !dockerGeneratedOptions[key] {
+			liveOptions[key] = val
+		}
+	}
+	if !equalMaps(liveOptions, desired.Options) {
+		res = append(res, "options")
+	}
+	return res
+}
+
+// getSubnets returns the subnets of the pools together with their ip ranges.
+func getSubnets(pools []dockerNetwork.IPAMConfig) []string {
+	res := make([]string, len(pools))
+	for i, pool := range pools {
+		res[i] = pool.Subnet + " " + pool.IPRange
+	}
+	sort.Strings(res)
+	return res
+}
+
+// equalMaps compares the maps considering nil equal to empty.
+func equalMaps(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, val := range a {
+		if other, ok := b[key]; !ok || other != val {
+			return false
+		}
+	}
+	return true
+}
+
+// updateAttachments disconnects the containers to detach and connects those to attach, given by id or name. Containers
+// already in the wanted state are left as they are, the others attached to the network are not touched.
+func (h PluginImpl) updateAttachments(cl *docker.Client, live types.NetworkResource, attach, detach []string) error {
+	for _, containerId := range detach {
+		if !isAttached(live, containerId) {
+			continue
+		}
+		h.Logger.Debugf("Disconnecting container [%s] from network [%s]", containerId, live.Name)
+		if err := cl.NetworkDisconnect(h.ctx, live.ID, containerId, true); err != nil {
+			h.Logger.Errorf("Error disconnecting container [%s] from network [%s]: %v", containerId, live.Name, err)
+			return err
+		}
+	}
+	for _, containerId := range attach {
+		if isAttached(live, containerId) {
+			continue
+		}
+		h.Logger.Debugf("Connecting container [%s] to network [%s]", containerId, live.Name)
+		if err := cl.NetworkConnect(h.ctx, live.ID, containerId, &dockerNetwork.EndpointSettings{}); err != nil {
+			h.Logger.Errorf("Error connecting container [%s] to network [%s]: %v", containerId, live.Name, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// isAttached tells whether the container with the given id, id prefix or name is attached to the network.
+func isAttached(networkResource types.NetworkResource, container string) bool {
+	for id, endpoint := range networkResource.Containers {
+		if strings.HasPrefix(id, container) || endpoint.Name == strings.TrimPrefix(container, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// getGateway returns the first address of the subnet, both for IPv4 and IPv6. The subnet needs at least two bits for
+// the hosts.
+func getGateway(cidr string) (string, error) {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	ones, bits := subnet.Mask.Size()
+	if bits-ones < 2 {
+		return "", errors.New(fmt.Sprintf("subnet %s is too small to have a gateway", cidr))
+	}
+	ip := make(net.IP, len(subnet.IP))
+	copy(ip, subnet.IP)
+	inc(ip)
+	return ip.String(), nil
+}
+
+func isIPv6Subnet(cidr string) bool {
+	ip, _, err := net.ParseCIDR(cidr)
+	return err == nil && ip.To4() == nil
+}
+
+// hasIPv6Pool tells whether one of the pools is an IPv6 subnet, which requires IPv6 to be enabled on the network.
+func hasIPv6Pool(pools []dockerNetwork.IPAMConfig) bool {
+	for _, pool := range pools {
+		if isIPv6Subnet(pool.Subnet) {
+			return true
+		}
+	}
+	return false
+}
+
+// getIPAMConfigs returns one pool for each of the comma separated subnets, with its gateway.
+func getIPAMConfigs(subnets string) ([]dockerNetwork.IPAMConfig, error) {
+	res := make([]dockerNetwork.IPAMConfig, 0)
+	for _, subnet := range strings.Split(subnets, ",") {
+		subnet = strings.TrimSpace(subnet)
+		if subnet == "" {
+			continue
+		}
+		subnet, err := canonicalCIDR(subnet)
+		if err != nil {
+			return nil, err
+		}
+		gateway, err := getGateway(subnet)
+		if err != nil {
+			return nil, err
+		}
+		if err = checkOverlap(subnet, res); err != nil {
+			return nil, err
+		}
+		res = append(res, dockerNetwork.IPAMConfig{
+			Subnet:  subnet,
+			Gateway: gateway,
+		})
+	}
+	return res, nil
+}
+
+// checkOverlap fails if the subnet overlaps with one of the pools.
+func checkOverlap(subnet string, pools []dockerNetwork.IPAMConfig) error {
+	_, n, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return err
+	}
+	for _, pool := range pools {
+		_, p, err := net.ParseCIDR(pool.Subnet)
+		if err != nil {
+			continue
+		}
+		if n.Contains(p.IP) || p.Contains(n.IP) {
+			return errors.New(fmt.Sprintf("subnet %s overlaps with %s", subnet, pool.Subnet))
+		}
+	}
+	return nil
+}
+
+// canonicalCIDR returns cidr with the host bits cleared, e.g. 10.0.0.0/24 for 10.0.0.1/24, so that the same subnet
+// is always written the same way.
+func canonicalCIDR(cidr string) (string, error) {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	return n.String(), nil
+}
+
+// findPool returns the pool for the canonical cidr, if any.
+func findPool(cidr string, pools []dockerNetwork.IPAMConfig) (dockerNetwork.IPAMConfig, bool) {
+	for _, pool := range pools {
+		if c, err := canonicalCIDR(pool.Subnet); err == nil && c == cidr {
+			return pool, true
+		}
+	}
+	return dockerNetwork.IPAMConfig{}, false
+}
+
+// subnetExtID identifies a pool of a network. Docker has no ids for pools, the network name is used instead of its id
+// since the network id changes when the network is recreated to change its pools.
+func subnetExtID(networkName, cidr string) string {
+	sum := sha256.Sum256([]byte(networkName + "/" + cidr))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// networkCreateFromResource returns the options to create the network again as it is.
+func networkCreateFromResource(networkResource types.NetworkResource) types.NetworkCreate {
+	ipam := networkResource.IPAM
+	ipam.Config = append([]dockerNetwork.IPAMConfig{}, networkResource.IPAM.Config...)
+	return types.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         networkResource.Driver,
+		EnableIPv6:     networkResource.EnableIPv6,
+		IPAM:           &ipam,
+		Internal:       networkResource.Internal,
+		Attachable:     networkResource.Attachable,
+		Options:        networkResource.Options,
+		Labels:         networkResource.Labels,
+	}
+}
+
+// recreateNetwork replaces the network with a new one with the same name created with the given options. Attached
+// containers are disconnected and connected again to the new network keeping their addresses. If the new network cannot
+// be created the old one is restored.
+func (h PluginImpl) recreateNetwork(cl *docker.Client, old types.NetworkResource, create types.NetworkCreate) (types.NetworkResource, error) {
+	endpoints := make(map[string]*dockerNetwork.EndpointSettings)
+	for containerId := range old.Containers {
+		c, err := cl.ContainerInspect(h.ctx, containerId)
+		if err != nil {
+			h.Logger.Errorf("Error inspecting container [%s]: %v", containerId, err)
+			return types.NetworkResource{}, err
+		}
+		endpoint, ok := c.NetworkSettings.Networks[old.Name]
+		if !ok {
+			continue
+		}
+		// addresses not fitting into the new pools are left to docker
+		ipamConfig := &dockerNetwork.EndpointIPAMConfig{}
+		if inPools(endpoint.IPAddress, create.IPAM.Config) {
+			ipamConfig.IPv4Address = endpoint.IPAddress
+		}
+		if inPools(endpoint.GlobalIPv6Address, create.IPAM.Config) {
+			ipamConfig.IPv6Address = endpoint.GlobalIPv6Address
+		}
+		endpoints[containerId] = &dockerNetwork.EndpointSettings{
+			Aliases:    endpoint.Aliases,
+			IPAMConfig: ipamConfig,
+		}
+	}
+	for containerId := range endpoints {
+		if err := cl.NetworkDisconnect(h.ctx, old.ID, containerId, true); err != nil {
+			h.Logger.Errorf("Error disconnecting container [%s] from network [%s]: %v", containerId, old.Name, err)
+			h.reconnect(cl, old.ID, endpoints)
+			return types.NetworkResource{}, err
+		}
+	}
+	if err := cl.NetworkRemove(h.ctx, old.ID); err != nil {
+		h.Logger.Errorf("Error removing network [%s]: %v", old.Name, err)
+		h.reconnect(cl, old.ID, endpoints)
+		return types.NetworkResource{}, err
+	}
+	h.Logger.Debugf("Recreating network [%s] with config %v", old.Name, create)
+	resp, err := cl.NetworkCreate(h.ctx, old.Name, create)
+	if err != nil {
+		h.Logger.Errorf("Error creating network [%s], restoring the old one: %v", old.Name, err)
+		restored, restoreErr := cl.NetworkCreate(h.ctx, old.Name, networkCreateFromResource(old))
+		if restoreErr != nil {
+			h.Logger.Errorf("Error restoring network [%s]: %v", old.Name, restoreErr)
+		} else {
+			h.reconnect(cl, restored.ID, endpoints)
+		}
+		return types.NetworkResource{}, err
+	}
+	h.reconnect(cl, resp.ID, endpoints)
+	return cl.NetworkInspect(h.ctx, resp.ID, types.NetworkInspectOptions{})
+}
+
+func inPools(ip string, pools []dockerNetwork.IPAMConfig) bool {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+	for _, pool := range pools {
+		if _, subnet, err := net.ParseCIDR(pool.Subnet); err == nil && subnet.Contains(parsedIP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h PluginImpl) reconnect(cl *docker.Client, networkId string, endpoints map[string]*dockerNetwork.EndpointSettings) {
+	for containerId, endpoint := range endpoints {
+		if err := cl.NetworkConnect(h.ctx, networkId, containerId, endpoint); err != nil {
+			h.Logger.Errorf("Error connecting container [%s] to network [%s]: %v", containerId, networkId, err)
+		}
+	}
+}
+
+// network delete policies, chosen through the networkDeletePolicyKey vim instance metadata entry
+const (
+	networkDeletePolicyKey = "network-delete-policy"
+	// DeletePolicyRefuse fails deleting networks with attached containers
+	DeletePolicyRefuse = "refuse"
+	// DeletePolicyDetach disconnects the attached containers before deleting the network
+	DeletePolicyDetach = "detach"
+)
+
+// getManagedNetwork inspects the network and fails if it was not created by the driver.
+func (h PluginImpl) getManagedNetwork(cl *docker.Client, id string) (types.NetworkResource, error) {
+	networkResource, err := cl.NetworkInspect(h.ctx, id, types.NetworkInspectOptions{})
+	if err != nil {
+		if docker.IsErrNotFound(err) {
+			return networkResource, &NotFoundError{Kind: "network", ID: id}
+		}
+		return networkResource, err
+	}
+	if !h.isOwned(networkResource.Labels) {
+		return networkResource, &NotManagedError{Kind: "network", Name: networkResource.Name}
+	}
+	return networkResource, nil
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"docker.io/go-docker"
+	"docker.io/go-docker/api/types"
+)
+
+// unlimitedQuota is used for the resources docker does not limit
+const unlimitedQuota = 100000
+
+// hostCapacity is the amount of cpu in nano cpus and memory in bytes
+type hostCapacity struct {
+	nanoCPUs int64
+	memory   int64
+}
+
+// getTotalCapacity returns the resources of the docker host. In swarm mode too the containers are launched on the
+// docker host of the vim instance, never on the other nodes, so their resources are not counted.
+func (h PluginImpl) getTotalCapacity(cl *docker.Client) (*hostCapacity, error) {
+	info, err := cl.Info(h.ctx)
+	if err != nil {
+		h.Logger.Errorf("Error getting docker info: %v", err)
+		return nil, err
+	}
+	return &hostCapacity{
+		nanoCPUs: int64(info.NCPU) * 1e9,
+		memory:   info.MemTotal,
+	}, nil
+}
+
+// getReservedCapacity returns the resources reserved by the flavours of the running containers launched by the driver
+// and their number.
+func (h PluginImpl) getReservedCapacity(cl *docker.Client) (*hostCapacity, int, error) {
+	containers, err := cl.ContainerList(h.ctx, types.ContainerListOptions{
+		Filters: h.getOwnedFilter(),
+	})
+	if err != nil {
+		h.Logger.Errorf("Error listing containers: %v", err)
+		return nil, 0, err
+	}
+	res := &hostCapacity{}
+	for _, c := range containers {
+		inspect, err := cl.ContainerInspect(h.ctx, c.ID)
+		if err != nil {
+			if docker.IsErrNotFound(err) {
+				continue
+			}
+			h.Logger.Errorf("Error inspecting container: %v", err)
+			return nil, 0, err
+		}
+		if inspect.HostConfig == nil {
+			continue
+		}
+		res.nanoCPUs += inspect.HostConfig.NanoCPUs
+		res.memory += inspect.HostConfig.Memory
+	}
+	return res, len(containers), nil
+}
+
+// available returns what is left of the capacity, in cores and MB, never less than zero.
+func (c *hostCapacity) available(reserved *hostCapacity) (int, int) {
+	cores := (c.nanoCPUs - reserved.nanoCPUs) / 1e9
+	ram := (c.memory - reserved.memory) / (1024 * 1024)
+	if cores < 0 {
+		cores = 0
+	}
+	if ram < 0 {
+		ram = 0
+	}
+	return int(cores), int(ram)
+}
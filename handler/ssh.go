@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/openbaton/go-openbaton/catalogue"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultSSHPort      = "22"
+	defaultRemoteSocket = "/var/run/docker.sock"
+	// sshConnectTimeout bounds establishing the ssh connection, the requests through it are bounded by their operation
+	sshConnectTimeout = 10 * time.Second
+)
+
+// sshDialer tunnels connections to the docker socket of a remote host over a single ssh connection, established when
+// first needed and again after it broke.
+type sshDialer struct {
+	addr   string
+	socket string
+	config *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// newSSHDialer returns the dialer for an ssh://user@host[:port][/path/to/docker.sock] docker host, authenticating with
+// the pem encoded private key in the DockerKey field of instance and verifying the host key against knownHosts.
+func newSSHDialer(instance *catalogue.DockerVimInstance, knownHosts string) (*sshDialer, error) {
+	u, err := url.Parse(instance.AuthURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("ssh docker host %s has no user", instance.AuthURL)
+	}
+	if instance.DockerKey == "" {
+		return nil, fmt.Errorf("vim instance %s has no private key for ssh docker host %s", instance.Name, instance.AuthURL)
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(instance.DockerKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key of vim instance %s: %v", instance.Name, err)
+	}
+	if knownHosts == "" {
+		return nil, fmt.Errorf("a known_hosts file is needed to verify ssh docker host %s", instance.AuthURL)
+	}
+	hostKeyCallback, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("error reading known_hosts file %s: %v", knownHosts, err)
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultSSHPort
+	}
+	socket := u.Path
+	if socket == "" {
+		socket = defaultRemoteSocket
+	}
+	return &sshDialer{
+		addr:   net.JoinHostPort(u.Hostname(), port),
+		socket: socket,
+		config: &ssh.ClientConfig{
+			User:            u.User.Username(),
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         sshConnectTimeout,
+		},
+	}, nil
+}
+
+// dial opens a connection to the remote docker socket, the arguments are ignored.
+func (d *sshDialer) dial(_, _ string) (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client != nil {
+		conn, err := d.client.Dial("unix", d.socket)
+		if err == nil {
+			return conn, nil
+		}
+		// the ssh connection may have broken, try once more with a new one
+		d.client.Close()
+		d.client = nil
+	}
+	client, err := ssh.Dial("tcp", d.addr, d.config)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %v", d.addr, err)
+	}
+	conn, err := client.Dial("unix", d.socket)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("error connecting to %s on %s: %v", d.socket, d.addr, err)
+	}
+	d.client = client
+	return conn, nil
+}
+
+// Close closes the ssh connection, if any.
+func (d *sshDialer) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client == nil {
+		return nil
+	}
+	err := d.client.Close()
+	d.client = nil
+	return err
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"context"
+	"time"
+)
+
+// Operation is a kind of plugin operation, each kind having its own timeout.
+type Operation string
+
+const (
+	OperationPull   Operation = "pull"
+	OperationCreate Operation = "create"
+	OperationList   Operation = "list"
+	OperationDelete Operation = "delete"
+)
+
+// DefaultTimeouts are the timeouts of the operations missing in PluginImpl.Timeouts.
+var DefaultTimeouts = map[Operation]time.Duration{
+	OperationPull:   10 * time.Minute,
+	OperationCreate: 5 * time.Minute,
+	OperationList:   time.Minute,
+	OperationDelete: 2 * time.Minute,
+}
+
+func (h PluginImpl) getTimeout(op Operation) time.Duration {
+	if timeout, ok := h.Timeouts[op]; ok && timeout > 0 {
+		return timeout
+	}
+	return DefaultTimeouts[op]
+}
+
+// begin returns a copy of h whose docker calls are cancelled once the timeout of op expires, and the function to call
+// with the error of the operation when it is done. That function releases the context and turns the error into a
+// TimeoutError if the timeout expired.
+func (h PluginImpl) begin(op Operation) (PluginImpl, func(*error)) {
+	timeout := h.getTimeout(op)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	h.ctx = ctx
+	return h, func(err *error) {
+		if err != nil && *err != nil && ctx.Err() == context.DeadlineExceeded {
+			h.Logger.Errorf("The %s operation did not complete within %v: %v", op, timeout, *err)
+			*err = &TimeoutError{Operation: op, Timeout: timeout}
+		}
+		cancel()
+	}
+}
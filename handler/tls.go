@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/go-connections/tlsconfig"
+	"github.com/openbaton/go-openbaton/catalogue"
+)
+
+const (
+	caFile   = "ca.pem"
+	certFile = "cert.pem"
+	keyFile  = "key.pem"
+)
+
+// getTLSConfig returns the tls configuration for the docker host of instance, or nil if tls is not used. The
+// certificates are taken from the vim instance or, with tls enabled and none in the vim instance, from the directory
+// named after the vim instance in CertDirectory or from CertDirectory itself. Nothing is written to disk.
+func (h *PluginImpl) getTLSConfig(instance *catalogue.DockerVimInstance) (*tls.Config, error) {
+	ca, cert, key := []byte(instance.Ca), []byte(instance.Cert), []byte(instance.DockerKey)
+	if len(ca) == 0 && len(cert) == 0 && len(key) == 0 {
+		if !h.Tsl {
+			return nil, nil
+		}
+		var err error
+		ca, cert, key, err = readCertDirectory(h.CertDirectory, instance)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newTLSConfig(instance.AuthURL, ca, cert, key)
+}
+
+// readCertDirectory reads ca.pem, cert.pem and key.pem from the directory of instance in dir, or from dir itself.
+func readCertDirectory(dir string, instance *catalogue.DockerVimInstance) ([]byte, []byte, []byte, error) {
+	for _, d := range []string{filepath.Join(dir, instance.Name), dir} {
+		if !exists(filepath.Join(d, caFile)) && !exists(filepath.Join(d, certFile)) {
+			continue
+		}
+		var content [3][]byte
+		for i, name := range []string{caFile, certFile, keyFile} {
+			c, err := ioutil.ReadFile(filepath.Join(d, name))
+			if err != nil && !os.IsNotExist(err) {
+				return nil, nil, nil, err
+			}
+			content[i] = c
+		}
+		return content[0], content[1], content[2], nil
+	}
+	return nil, nil, nil, &CertificateError{
+		Host:   instance.AuthURL,
+		Reason: fmt.Sprintf("no certificates in the vim instance, in %s nor in %s", filepath.Join(dir, instance.Name), dir),
+	}
+}
+
+// newTLSConfig returns the tls configuration trusting the pem encoded ca, or the system ones if empty, and
+// authenticating with the pem encoded cert and key if not empty.
+func newTLSConfig(host string, ca, cert, key []byte) (*tls.Config, error) {
+	config := tlsconfig.ClientDefault()
+	if len(ca) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, &CertificateError{Host: host, Reason: "the CA certificate is not a valid pem encoded certificate"}
+		}
+		config.RootCAs = pool
+	}
+	if len(cert) == 0 && len(key) == 0 {
+		return config, nil
+	}
+	pair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return nil, &CertificateError{Host: host, Reason: fmt.Sprintf("invalid client certificate or key: %v", err)}
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, &CertificateError{Host: host, Reason: fmt.Sprintf("invalid client certificate: %v", err)}
+	}
+	if now := time.Now(); now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return nil, &CertificateError{
+			Host:   host,
+			Reason: fmt.Sprintf("the client certificate is only valid from %s to %s", leaf.NotBefore, leaf.NotAfter),
+		}
+	}
+	config.Certificates = []tls.Certificate{pair}
+	return config, nil
+}
+
+// isCertificateError tells whether err comes from the verification of the certificate presented by a docker host. The
+// docker client does not keep the original error, hence the message is checked.
+func isCertificateError(err error) bool {
+	return strings.Contains(err.Error(), "x509:")
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"docker.io/go-docker"
+	"github.com/op/go-logging"
+)
+
+const (
+	StatusBuild  = "BUILD"
+	StatusActive = "ACTIVE"
+	StatusError  = "ERROR"
+)
+
+// launchTracker keeps the status of the containers started by LaunchInstance while they are starting and after they
+// failed to. Running containers are not kept, their status comes from docker.
+type launchTracker struct {
+	mu       sync.RWMutex
+	statuses map[string]string
+}
+
+func newLaunchTracker() *launchTracker {
+	return &launchTracker{
+		statuses: make(map[string]string),
+	}
+}
+
+func (t *launchTracker) set(containerId, status string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.statuses[containerId] = status
+}
+
+func (t *launchTracker) status(containerId string) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	status, ok := t.statuses[containerId]
+	return status, ok
+}
+
+func (t *launchTracker) forget(containerId string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.statuses, containerId)
+}
+
+// track watches the container in the background, the wait function blocks until it is running or returns the reason
+// why it is not.
+func (t *launchTracker) track(logger *logging.Logger, cl *docker.Client, containerId string, wait func(*docker.Client, string) error) {
+	t.set(containerId, StatusBuild)
+	go func() {
+		start := time.Now()
+		if err := wait(cl, containerId); err != nil {
+			logger.Errorf("Container [%s] did not reach the running state: %v", containerId, err)
+			t.set(containerId, StatusError)
+			return
+		}
+		logger.Infof("Container [%s] running after %v", containerId, time.Since(start))
+		t.forget(containerId)
+	}()
+}
@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"docker.io/go-docker/api/types/strslice"
+)
+
+// userdataHeader is the first line the user-data must start with in order to be used, followed by one of the modes.
+// User-data without it is meant for virtual machines and is ignored.
+const userdataHeader = "#docker-userdata:"
+
+const (
+	// UserdataEnv turns every KEY=VALUE line into an environment variable of the container
+	UserdataEnv = "env"
+	// UserdataInit runs the script before the entrypoint of the image
+	UserdataInit = "init"
+	// UserdataEntrypoint runs the script instead of the entrypoint of the image
+	UserdataEntrypoint = "entrypoint"
+)
+
+var userdataScriptPath = "/openbaton/userdata.sh"
+
+type userdataConfig struct {
+	mode   string
+	env    []string
+	script []byte
+}
+
+// parseUserdata returns nil if the user-data does not start with the userdataHeader.
+func parseUserdata(userdata string) (*userdataConfig, error) {
+	if !strings.HasPrefix(userdata, userdataHeader) {
+		return nil, nil
+	}
+	lines := strings.SplitN(userdata, "\n", 2)
+	res := &userdataConfig{
+		mode: strings.TrimSpace(strings.TrimPrefix(lines[0], userdataHeader)),
+	}
+	var body string
+	if len(lines) > 1 {
+		body = lines[1]
+	}
+	switch res.mode {
+	case UserdataEnv:
+		scanner := bufio.NewScanner(strings.NewReader(body))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			line = strings.TrimPrefix(line, "export ")
+			if strings.Index(line, "=") < 1 {
+				return nil, errors.New(fmt.Sprintf("user-data line [%s] is not of the form KEY=VALUE", line))
+			}
+			res.env = append(res.env, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	case UserdataInit, UserdataEntrypoint:
+		if !strings.HasPrefix(body, "#!") {
+			return nil, errors.New(fmt.Sprintf("user-data in mode %s must be a script starting with #!", res.mode))
+		}
+		res.script = []byte(body)
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown user-data mode [%s], use one of %s, %s, %s", res.mode, UserdataEnv, UserdataInit, UserdataEntrypoint))
+	}
+	return res, nil
+}
+
+// getEntrypoint returns the entrypoint running the user-data script given the one of the image and its command.
+func (u *userdataConfig) getEntrypoint(imageEntrypoint, imageCmd strslice.StrSlice) strslice.StrSlice {
+	if u.mode == UserdataEntrypoint {
+		return strslice.StrSlice{userdataScriptPath}
+	}
+	res := strslice.StrSlice{"/bin/sh", "-c", userdataScriptPath + ` && exec "$@"`, "userdata"}
+	res = append(res, imageEntrypoint...)
+	return append(res, imageCmd...)
+}
+
+// getUserdataMode returns the mode of the user-data an entrypoint was built for by getEntrypoint, or an empty string if
+// it does not run a user-data script.
+func getUserdataMode(entrypoint strslice.StrSlice) string {
+	if len(entrypoint) == 1 && entrypoint[0] == userdataScriptPath {
+		return UserdataEntrypoint
+	}
+	prefix := (&userdataConfig{mode: UserdataInit}).getEntrypoint(nil, nil)
+	if len(entrypoint) < len(prefix) {
+		return ""
+	}
+	for i := range prefix {
+		if entrypoint[i] != prefix[i] {
+			return ""
+		}
+	}
+	return UserdataInit
+}
+
+// getScriptArchive returns a tar archive to be extracted in the root of the container with the executable script.
+func (u *userdataConfig) getScriptArchive() (*bytes.Buffer, error) {
+	return getFileArchive(userdataScriptPath, 0755, u.script, nil)
+}
+
+// containerFile is what was found in a container of a file and its parent directory, see readContainerFile.
+type containerFile struct {
+	// dir and file are nil if they do not exist
+	dir     *tar.Header
+	file    *tar.Header
+	content []byte
+}
+
+// readFileArchive reads the archive of the parent directory of path, as returned by CopyFromContainer.
+func readFileArchive(r io.Reader, path string) (*containerFile, error) {
+	dirName := filepath.Base(filepath.Dir(path))
+	fileName := dirName + "/" + filepath.Base(path)
+	res := &containerFile{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch strings.TrimSuffix(hdr.Name, "/") {
+		case dirName:
+			res.dir = hdr
+		case fileName:
+			res.file = hdr
+			if res.content, err = ioutil.ReadAll(tr); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// getFileArchive returns a tar archive to be extracted in the grandparent directory of path, containing the parent
+// directory of path and the file itself. If existing tells that the directory is already there it is left untouched
+// and the file gets the owner and mode of the existing file, or the owner of the directory.
+func getFileArchive(path string, mode int64, content []byte, existing *containerFile) (*bytes.Buffer, error) {
+	if !filepath.IsAbs(path) || filepath.Dir(path) == "/" {
+		return nil, errors.New(fmt.Sprintf("path %s must be absolute and inside a directory", path))
+	}
+	if existing == nil {
+		existing = &containerFile{}
+	}
+	dir := filepath.Base(filepath.Dir(path))
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	file := &tar.Header{
+		Name:     dir + "/" + filepath.Base(path),
+		Mode:     mode,
+		Size:     int64(len(content)),
+		Typeflag: tar.TypeReg,
+	}
+	if existing.file != nil {
+		file.Mode = existing.file.Mode
+		file.Uid, file.Gid = existing.file.Uid, existing.file.Gid
+	} else if existing.dir != nil {
+		file.Uid, file.Gid = existing.dir.Uid, existing.dir.Gid
+	}
+	if existing.dir == nil {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     dir + "/",
+			Mode:     0700 | (mode & 0055),
+			Typeflag: tar.TypeDir,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := tw.WriteHeader(file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tw.Write(content); err != nil {
+		return nil, err
+	}
+	if err = tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
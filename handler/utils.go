@@ -1,12 +1,18 @@
 package handler
 
 import (
-	"github.com/openbaton/go-openbaton/catalogue"
-	"docker.io/go-docker/api/types"
-	"strings"
-	"os"
+	"bytes"
 	"errors"
 	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/container"
+	dockerNetwork "docker.io/go-docker/api/types/network"
+	"github.com/openbaton/go-openbaton/catalogue"
 )
 
 func GetImage(img types.ImageSummary) (*catalogue.DockerImage, error) {
@@ -19,12 +25,35 @@ func GetImage(img types.ImageSummary) (*catalogue.DockerImage, error) {
 }
 
 func GetNetwork(networkResource types.NetworkResource) (*catalogue.DockerNetwork, error) {
-	var gateway, subnet string
-	if len(networkResource.IPAM.Config) > 0 {
-		gateway = networkResource.IPAM.Config[0].Gateway
-		subnet = networkResource.IPAM.Config[0].Subnet
+	// every pool is exposed, comma separated as accepted by CreateNetwork
+	gateways := make([]string, len(networkResource.IPAM.Config))
+	subnets := make([]string, len(networkResource.IPAM.Config))
+	for i, pool := range networkResource.IPAM.Config {
+		gateways[i] = pool.Gateway
+		subnets[i] = pool.Subnet
+	}
+	gateway := strings.Join(gateways, ",")
+	subnet := strings.Join(subnets, ",")
+	metadata := map[string]string{
+		"driver": networkResource.Driver,
+	}
+	if networkResource.EnableIPv6 {
+		metadata["ipv6"] = "true"
+	}
+	if networkResource.IPAM.Driver != "" {
+		metadata["ipam-driver"] = networkResource.IPAM.Driver
 	}
-	return &catalogue.DockerNetwork{
+	setNetworkOptionsMetadata(networkResource, metadata)
+	// the containers are only known when the network is inspected, not when listed
+	if len(networkResource.Containers) > 0 {
+		containers := make([]string, 0, len(networkResource.Containers))
+		for id := range networkResource.Containers {
+			containers = append(containers, id)
+		}
+		sort.Strings(containers)
+		metadata[containersKey] = strings.Join(containers, ",")
+	}
+	res := &catalogue.DockerNetwork{
 		Driver:  networkResource.Driver,
 		Scope:   networkResource.Scope,
 		Gateway: gateway,
@@ -33,7 +62,9 @@ func GetNetwork(networkResource types.NetworkResource) (*catalogue.DockerNetwork
 			Name:  networkResource.Name,
 			ExtID: networkResource.ID,
 		},
-	}, nil
+	}
+	res.Metadata = metadata
+	return res, nil
 }
 
 func GetContainer(container types.Container, image *catalogue.DockerImage) (*catalogue.Server, error) {
@@ -50,12 +81,10 @@ func GetContainer(container types.Container, image *catalogue.DockerImage) (*cat
 		InstanceName:   container.Names[0],
 		Name:           container.Names[0],
 		HostName:       container.Names[0],
-		Flavour: &catalogue.DeploymentFlavour{
-			FlavourKey: "m1.small",
-		},
-		Image:       image,
-		IPs:         ips,
-		FloatingIPs: fips,
+		Flavour:        getFlavour(container.Labels),
+		Image:          image,
+		IPs:            ips,
+		FloatingIPs:    fips,
 	}, nil
 }
 
@@ -74,12 +103,10 @@ func GetContainerWithImgName(container types.Container, img types.ImageInspect)
 		InstanceName:   container.Names[0],
 		Name:           container.Names[0],
 		HostName:       container.Names[0],
-		Flavour: &catalogue.DeploymentFlavour{
-			FlavourKey: "m1.small",
-		},
-		Image:       image,
-		IPs:         ips,
-		FloatingIPs: fips,
+		Flavour:        getFlavour(container.Labels),
+		Image:          image,
+		IPs:            ips,
+		FloatingIPs:    fips,
 	}, nil
 }
 func GetImageFromInspect(img types.ImageInspect) (*catalogue.DockerImage, error) {
@@ -100,7 +127,16 @@ func stringInSlice(a string, list []string) bool {
 	return false
 }
 
-func exists(path string) (bool) {
+func stringEqualInSlice(a string, list []string) bool {
+	for _, b := range list {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
+
+func exists(path string) bool {
 	_, err := os.Stat(path)
 	if err == nil {
 		return true
@@ -131,3 +167,92 @@ func getDockerNet(net catalogue.BaseNetworkInt) (*catalogue.DockerNetwork, error
 		return nil, errors.New(fmt.Sprintf("network not of type DockerNetwork but [%T]", net))
 	}
 }
+
+// getRequestedIP returns the first address found in the ips map under one of the keys. The "random" value used by the
+// NFVO for floating ips means no specific address.
+func getRequestedIP(ips map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if ip, ok := ips[key]; ok && key != "" && ip != "random" {
+			return ip
+		}
+	}
+	return ""
+}
+
+// getEndpointIPAMConfig checks that the ip belongs to one of the subnets of the network and returns the endpoint
+// configuration requesting it.
+func getEndpointIPAMConfig(ip string, networkResource types.NetworkResource) (*dockerNetwork.EndpointIPAMConfig, error) {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return nil, errors.New(fmt.Sprintf("%s is not a valid ip address", ip))
+	}
+	for _, config := range networkResource.IPAM.Config {
+		_, subnet, err := net.ParseCIDR(config.Subnet)
+		if err != nil || !subnet.Contains(parsedIP) {
+			continue
+		}
+		if parsedIP.To4() != nil {
+			return &dockerNetwork.EndpointIPAMConfig{IPv4Address: ip}, nil
+		}
+		return &dockerNetwork.EndpointIPAMConfig{IPv6Address: ip}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("ip %s is not part of any subnet of network %s", ip, networkResource.Name))
+}
+
+// getAuthorizedKeysArchive returns a tar archive to be extracted in the grandparent directory of path, containing the
+// parent directory of path and the authorized keys file with one public key per line. The keys are added to those of
+// the existing file, if any.
+func getAuthorizedKeysArchive(keys []*catalogue.Key, path string, existing *containerFile) (*bytes.Buffer, error) {
+	var content bytes.Buffer
+	present := make(map[string]bool)
+	if existing != nil && existing.file != nil {
+		for _, line := range strings.Split(string(existing.content), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				present[line] = true
+				content.WriteString(line)
+				content.WriteString("\n")
+			}
+		}
+	}
+	for _, key := range keys {
+		if key == nil || key.PublicKey == "" || present[strings.TrimSpace(key.PublicKey)] {
+			continue
+		}
+		present[strings.TrimSpace(key.PublicKey)] = true
+		content.WriteString(strings.TrimSpace(key.PublicKey))
+		content.WriteString("\n")
+	}
+	return getFileArchive(path, 0600, content.Bytes(), existing)
+}
+
+// isPredefinedNetwork tells whether the network is one of those docker creates by itself.
+func isPredefinedNetwork(name string) bool {
+	return name == "bridge" || name == "host" || name == "none"
+}
+
+func isMountTarget(hostConfig *container.HostConfig, target string) bool {
+	for _, m := range hostConfig.Mounts {
+		if m.Target == target {
+			return true
+		}
+	}
+	for _, bind := range hostConfig.Binds {
+		parts := strings.Split(bind, ":")
+		if len(parts) > 1 && parts[1] == target {
+			return true
+		}
+	}
+	return false
+}
+
+// getFlavour returns the flavour the container was launched with, as far as its labels tell.
+func getFlavour(labels map[string]string) *catalogue.DeploymentFlavour {
+	if key, ok := labels[flavourLabel]; ok {
+		return &catalogue.DeploymentFlavour{
+			FlavourKey: key,
+		}
+	}
+	return &catalogue.DeploymentFlavour{
+		FlavourKey: defaultFlavour.FlavourKey,
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"flag"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/openbaton/go-docker-driver/handler"
 	"github.com/openbaton/go-openbaton/catalogue"
@@ -14,9 +16,19 @@ func main() {
 
 	var configFile = flag.String("conf", "", "The config file of the Docker Vim Driver")
 	var level = flag.String("level", "INFO", "The Log Level of the Docker Vim Driver")
-	var certDirectory = flag.String("cert", "/Users/usr/.docker/machine/machines/myvm1/", "The certificate directory")
+	var certDirectory = flag.String("cert", "/Users/usr/.docker/machine/machines/myvm1/", "The directory with ca.pem, cert.pem and key.pem, or with one such directory per vim instance name")
 	var swarm = flag.Bool("swarm", false, "if the plugin works against a swarm docker")
 	var tsl = flag.Bool("tsl", false, "use tsl or not")
+	var knownHosts = flag.String("known-hosts", filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts"), "The known_hosts file verifying the keys of ssh docker hosts")
+	var flavourFile = flag.String("flavours", "flavours.json", "The file where the flavours are stored")
+	var pullTimeout = flag.Duration("pull-timeout", handler.DefaultTimeouts[handler.OperationPull], "How long pulling an image may take")
+	var createTimeout = flag.Duration("create-timeout", handler.DefaultTimeouts[handler.OperationCreate], "How long creating a container or network may take")
+	var listTimeout = flag.Duration("list-timeout", handler.DefaultTimeouts[handler.OperationList], "How long listing or inspecting resources may take")
+	var deleteTimeout = flag.Duration("delete-timeout", handler.DefaultTimeouts[handler.OperationDelete], "How long deleting a container or network may take")
+	var gcInterval = flag.Duration("gc-interval", 0, "How often to look for orphan containers and networks, 0 disables it")
+	var gcGracePeriod = flag.Duration("gc-grace-period", time.Hour, "How long a container or network is orphan before being removed")
+	var gcKnownFile = flag.String("gc-known", "known-resources.json", "The json file with the ids or names of the containers and networks still in use")
+	var gcDryRun = flag.Bool("gc-dry-run", false, "Only report orphan containers and networks without removing them")
 
 	var typ = flag.String("type", "docker", "The type of the Docker Vim Driver")
 	var name = flag.String("name", "docker", "The name of the Docker Vim Driver")
@@ -34,11 +46,32 @@ func main() {
 	flag.Parse()
 
 	logger := sdk.GetLogger("docker-driver", *level)
-	h := &handler.PluginImpl{
-		Logger:        logger,
-		Swarm:         *swarm,
-		Tsl:           *tsl,
-		CertDirectory: *certDirectory,
+	h := handler.NewHandlerPlugin(*swarm)
+	h.Logger = logger
+	h.Tsl = *tsl
+	h.CertDirectory = *certDirectory
+	h.KnownHosts = *knownHosts
+	h.DriverID = *name
+	h.Timeouts = map[handler.Operation]time.Duration{
+		handler.OperationPull:   *pullTimeout,
+		handler.OperationCreate: *createTimeout,
+		handler.OperationList:   *listTimeout,
+		handler.OperationDelete: *deleteTimeout,
+	}
+	if err := h.LoadFlavours(*flavourFile); err != nil {
+		logger.Errorf("Error loading flavours: %v", err)
+		os.Exit(1)
+	}
+	if *gcInterval > 0 {
+		gc := &handler.GarbageCollector{
+			Handler:     h,
+			Known:       &handler.FileKnownResources{Path: *gcKnownFile},
+			Interval:    *gcInterval,
+			GracePeriod: *gcGracePeriod,
+			DryRun:      *gcDryRun,
+			Logger:      logger,
+		}
+		go gc.Run(make(chan struct{}))
 	}
 	if *configFile != "" {
 		pluginsdk.Start(*configFile, h, *name, catalogue.DockerNetwork{}, catalogue.DockerImage{})